@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Mirrors snippetResponse in cmd/web/api.go */
+type snippet struct {
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	Created        time.Time `json:"created"`
+	Expires        time.Time `json:"expires"`
+	Visibility     string    `json:"visibility"`
+	Language       string    `json:"language,omitempty"`
+	UserID         int       `json:"user_id,omitempty"`
+	OrganisationID int       `json:"organisation_id,omitempty"`
+}
+
+type snippetInput struct {
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Language   string `json:"language,omitempty"`
+	Expires    int    `json:"expires"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+type listMetadata struct {
+	Page     int  `json:"page"`
+	PageSize int  `json:"page_size"`
+	HasMore  bool `json:"has_more"`
+}
+
+// An error response from the API, either a single message or an error per
+// field
+type apiError struct {
+	Status      int
+	Message     string            `json:"error"`
+	FieldErrors map[string]string `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	if len(e.FieldErrors) == 0 {
+		return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+	}
+
+	fields := make([]string, 0, len(e.FieldErrors))
+	for field := range e.FieldErrors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field, e.FieldErrors[field]))
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+type client struct {
+	server     string
+	token      string
+	httpClient *http.Client
+}
+
+func (c *client) create(input snippetInput) (*snippet, error) {
+	var response struct {
+		Snippet *snippet `json:"snippet"`
+	}
+
+	err := c.do(http.MethodPost, "/api/v1/snippets", input, &response)
+	return response.Snippet, err
+}
+
+func (c *client) get(id int) (*snippet, error) {
+	var response struct {
+		Snippet *snippet `json:"snippet"`
+	}
+
+	err := c.do(http.MethodGet, "/api/v1/snippets/"+strconv.Itoa(id), nil, &response)
+	return response.Snippet, err
+}
+
+/* search may be empty to list everything */
+func (c *client) list(search string, page, pageSize int) ([]*snippet, *listMetadata, error) {
+	query := url.Values{}
+	if search != "" {
+		query.Set("q", search)
+	}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("page_size", strconv.Itoa(pageSize))
+
+	var response struct {
+		Snippets []*snippet    `json:"snippets"`
+		Metadata *listMetadata `json:"metadata"`
+	}
+
+	err := c.do(http.MethodGet, "/api/v1/snippets?"+query.Encode(), nil, &response)
+	return response.Snippets, response.Metadata, err
+}
+
+func (c *client) delete(id int) error {
+	return c.do(http.MethodDelete, "/api/v1/snippets/"+strconv.Itoa(id), nil, nil)
+}
+
+/* Url of the snippets page in the web interface */
+func (c *client) viewURL(id int) string {
+	return fmt.Sprintf("%s/snippet/view/%d", c.server, id)
+}
+
+// Sends body, if any, as JSON and decodes the response into dst, if any.
+// Error responses are returned as *apiError.
+func (c *client) do(method, path string, body any, dst any) error {
+	var r io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	rs, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+
+	if rs.StatusCode >= 400 {
+		e := &apiError{Status: rs.StatusCode}
+		if err := json.NewDecoder(rs.Body).Decode(e); err != nil || (e.Message == "" && len(e.FieldErrors) == 0) {
+			e.Message = http.StatusText(rs.StatusCode)
+		}
+		return e
+	}
+
+	if dst == nil || rs.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(rs.Body).Decode(dst)
+}
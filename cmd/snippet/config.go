@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Read from a JSON file, by default ~/.config/snippet/config.json:
+//
+//	{
+//		"server": "https://snippetbox.example",
+//		"token": "sbx_..."
+//	}
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+	/* Skips TLS certificate verification, for servers using the self signed development certificate */
+	Insecure bool `json:"insecure"`
+}
+
+/* path may be empty, in which case $SNIPPET_CONFIG or the default location is used */
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		path = os.Getenv("SNIPPET_CONFIG")
+	}
+
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, "snippet", "config.json")
+	}
+
+	js, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no config file at %s, create one with the server url and an API token from /user/account/tokens", path)
+		}
+		return nil, err
+	}
+
+	var cfg config
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cfg.Server = strings.TrimSuffix(cfg.Server, "/")
+	if cfg.Server == "" {
+		return nil, fmt.Errorf("%s: server must be set", path)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,380 @@
+// Command snippet is a command-line client for the snippetbox JSON API.
+//
+//	snippet create -t title -e 7d < file
+//	snippet get <id>
+//	snippet list
+//	snippet search <text>
+//	snippet delete <id>
+//
+// Every command takes -json to print the API's JSON rather than text, and
+// -config to read a config file other than ~/.config/snippet/config.json.
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: snippet <command> [flags] [arguments]
+
+Commands:
+  create [-t title] [-e 7d] [-v visibility] [-l language] [file]
+                     create a snippet from file or stdin and print its url
+  get <id>           print a snippet's content
+  list [-page n] [-n size]
+                     list public snippets
+  search [-page n] [-n size] <text>
+                     list public snippets containing text
+  delete <id>        delete one of your snippets
+
+Flags accepted by every command:
+  -json              print JSON instead of text
+  -config path       config file, default $SNIPPET_CONFIG or ~/.config/snippet/config.json
+`
+
+/* Exit codes */
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+var errUsage = errors.New("usage")
+
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+func (c *cli) run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+		return exitUsage
+	}
+
+	commands := map[string]func([]string) error{
+		"create": c.create,
+		"get":    c.get,
+		"list":   c.list,
+		"search": c.search,
+		"delete": c.delete,
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		fmt.Fprint(c.stdout, usage)
+		return exitOK
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(c.stderr, "snippet: unknown command %q\n\n%s", name, usage)
+		return exitUsage
+	}
+
+	err := command(args[1:])
+	if err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			return exitUsage
+		}
+		fmt.Fprintf(c.stderr, "snippet %s: %s\n", name, err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+/* Flags shared by every command */
+type commonFlags struct {
+	json   bool
+	config string
+}
+
+func (c *cli) newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	common := &commonFlags{}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.BoolVar(&common.json, "json", false, "print JSON instead of text")
+	fs.StringVar(&common.config, "config", "", "path of the config file")
+
+	return fs, common
+}
+
+// Parses flags wherever they are among the arguments, so both "get -json 1"
+// and "get 1 -json" work. Returns the remaining positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func (c *cli) newClient(common *commonFlags) (*client, error) {
+	cfg, err := loadConfig(common.config)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if cfg.Insecure {
+		httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	return &client{server: cfg.Server, token: cfg.Token, httpClient: httpClient}, nil
+}
+
+func (c *cli) create(args []string) error {
+	fs, common := c.newFlagSet("create")
+	title := fs.String("t", "Untitled", "title")
+	expires := fs.String("e", "365d", "expire after 1d, 7d or 365d (also 1w or 1y)")
+	visibility := fs.String("v", "public", "public, unlisted, team or private")
+	language := fs.String("l", "", "language, eg. go")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) > 1 {
+		fmt.Fprintln(c.stderr, "usage: snippet create [flags] [file]")
+		return errUsage
+	}
+
+	days, err := parseExpiry(*expires)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	if len(positional) == 1 {
+		content, err = os.ReadFile(positional[0])
+	} else {
+		content, err = io.ReadAll(c.stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	api, err := c.newClient(common)
+	if err != nil {
+		return err
+	}
+
+	s, err := api.create(snippetInput{
+		Title:      *title,
+		Content:    string(content),
+		Language:   *language,
+		Expires:    days,
+		Visibility: *visibility,
+	})
+	if err != nil {
+		return err
+	}
+
+	if common.json {
+		return c.printJSON(map[string]any{"snippet": s, "url": api.viewURL(s.ID)})
+	}
+
+	fmt.Fprintln(c.stdout, api.viewURL(s.ID))
+	return nil
+}
+
+func (c *cli) get(args []string) error {
+	fs, common := c.newFlagSet("get")
+
+	id, err := c.parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.newClient(common)
+	if err != nil {
+		return err
+	}
+
+	s, err := api.get(id)
+	if err != nil {
+		return err
+	}
+
+	if common.json {
+		return c.printJSON(map[string]any{"snippet": s})
+	}
+
+	fmt.Fprint(c.stdout, s.Content)
+	if !strings.HasSuffix(s.Content, "\n") {
+		fmt.Fprintln(c.stdout)
+	}
+	return nil
+}
+
+func (c *cli) list(args []string) error {
+	fs, common := c.newFlagSet("list")
+	page := fs.Int("page", 1, "page number")
+	pageSize := fs.Int("n", 20, "snippets per page")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) > 0 {
+		fmt.Fprintln(c.stderr, "usage: snippet list [-page n] [-n size]")
+		return errUsage
+	}
+
+	return c.printList(common, "", *page, *pageSize)
+}
+
+func (c *cli) search(args []string) error {
+	fs, common := c.newFlagSet("search")
+	page := fs.Int("page", 1, "page number")
+	pageSize := fs.Int("n", 20, "snippets per page")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) == 0 {
+		fmt.Fprintln(c.stderr, "usage: snippet search [-page n] [-n size] <text>")
+		return errUsage
+	}
+
+	return c.printList(common, strings.Join(positional, " "), *page, *pageSize)
+}
+
+func (c *cli) delete(args []string) error {
+	fs, common := c.newFlagSet("delete")
+
+	id, err := c.parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.newClient(common)
+	if err != nil {
+		return err
+	}
+
+	if err := api.delete(id); err != nil {
+		return err
+	}
+
+	if common.json {
+		return c.printJSON(map[string]any{"id": id, "deleted": true})
+	}
+
+	fmt.Fprintf(c.stdout, "Deleted snippet %d\n", id)
+	return nil
+}
+
+func (c *cli) printList(common *commonFlags, search string, page, pageSize int) error {
+	api, err := c.newClient(common)
+	if err != nil {
+		return err
+	}
+
+	snippets, metadata, err := api.list(search, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	if common.json {
+		return c.printJSON(map[string]any{"snippets": snippets, "metadata": metadata})
+	}
+
+	if len(snippets) == 0 {
+		fmt.Fprintln(c.stderr, "No snippets found")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tEXPIRES")
+	for _, s := range snippets {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, s.Title, s.Created.Format("2006-01-02"), s.Expires.Format("2006-01-02"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if metadata != nil && metadata.HasMore {
+		fmt.Fprintf(c.stderr, "More snippets on -page %d\n", metadata.Page+1)
+	}
+
+	return nil
+}
+
+/* For commands which take nothing but a snippet id */
+func (c *cli) parseID(fs *flag.FlagSet, args []string) (int, error) {
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(positional) != 1 {
+		fmt.Fprintf(c.stderr, "usage: snippet %s [flags] <id>\n", fs.Name())
+		return 0, errUsage
+	}
+
+	id, err := strconv.Atoi(positional[0])
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid snippet id %q", positional[0])
+	}
+
+	return id, nil
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+// Turns "7d", "1w", "1y" or "7" into a number of days. The server decides
+// which numbers of days it accepts.
+func parseExpiry(s string) (int, error) {
+	units := map[string]int{"d": 1, "w": 7, "y": 365}
+
+	n, multiplier := s, 1
+	if len(s) > 0 {
+		if m, ok := units[s[len(s)-1:]]; ok {
+			n, multiplier = s[:len(s)-1], m
+		}
+	}
+
+	days, err := strconv.Atoi(n)
+	if err != nil || days < 1 {
+		return 0, fmt.Errorf("invalid expiry %q, use eg. 1d, 7d or 365d", s)
+	}
+
+	return days * multiplier, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestCommands(t *testing.T) {
+	ts, _ := newTestServer(t)
+	cfg := writeTestConfig(t, ts.URL, testToken)
+	anonymous := writeTestConfig(t, ts.URL, "")
+
+	file := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(file, []byte("package main\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "Create from stdin",
+			args:       []string{"create", "-config", cfg, "-t", "dmesg", "-e", "7d"},
+			stdin:      "[    0.000000] Linux version",
+			wantCode:   exitOK,
+			wantStdout: ts.URL + "/snippet/view/2\n",
+		},
+		{
+			name:       "Create from file as JSON",
+			args:       []string{"create", "-config", cfg, "-l", "go", file, "-json"},
+			wantCode:   exitOK,
+			wantStdout: `"language": "go"`,
+		},
+		{
+			name:       "Create with field errors",
+			args:       []string{"create", "-config", cfg, "-e", "2d"},
+			stdin:      "",
+			wantCode:   exitError,
+			wantStderr: "content: This field cannot be blank\nexpires: This field must equal 1, 7 or 365.",
+		},
+		{
+			name:       "Create without token",
+			args:       []string{"create", "-config", anonymous},
+			stdin:      "hello",
+			wantCode:   exitError,
+			wantStderr: "you must be authenticated with an API token to access this resource (401)",
+		},
+		{
+			name:       "Create with invalid expiry",
+			args:       []string{"create", "-config", cfg, "-e", "soon"},
+			wantCode:   exitError,
+			wantStderr: `invalid expiry "soon"`,
+		},
+		{
+			name:       "Get",
+			args:       []string{"get", "-config", anonymous, "1"},
+			wantCode:   exitOK,
+			wantStdout: "An old silent pond...\n",
+		},
+		{
+			name:       "Get as JSON",
+			args:       []string{"get", "1", "-json", "-config", anonymous},
+			wantCode:   exitOK,
+			wantStdout: `"title": "An old silent pond"`,
+		},
+		{
+			name:       "Get non-existent snippet",
+			args:       []string{"get", "-config", cfg, "99"},
+			wantCode:   exitError,
+			wantStderr: "the requested resource could not be found (404)",
+		},
+		{
+			name:       "Get without id",
+			args:       []string{"get", "-config", cfg},
+			wantCode:   exitUsage,
+			wantStderr: "usage: snippet get [flags] <id>",
+		},
+		{
+			name:       "List",
+			args:       []string{"list", "-config", cfg},
+			wantCode:   exitOK,
+			wantStdout: "An old silent pond",
+		},
+		{
+			name:       "List with more pages",
+			args:       []string{"list", "-config", cfg, "-n", "1"},
+			wantCode:   exitOK,
+			wantStderr: "More snippets on -page 2",
+		},
+		{
+			name:       "Search",
+			args:       []string{"search", "-config", cfg, "silent", "pond"},
+			wantCode:   exitOK,
+			wantStdout: "An old silent pond",
+		},
+		{
+			name:       "Search without results",
+			args:       []string{"search", "-config", cfg, "nothing"},
+			wantCode:   exitOK,
+			wantStderr: "No snippets found",
+		},
+		{
+			name:       "Delete somebody elses snippet",
+			args:       []string{"delete", "-config", cfg, "1"},
+			wantCode:   exitError,
+			wantStderr: "you do not own this snippet (403)",
+		},
+		{
+			name:       "Delete",
+			args:       []string{"delete", "-config", cfg, "2"},
+			wantCode:   exitOK,
+			wantStdout: "Deleted snippet 2\n",
+		},
+		{
+			name:       "Missing config",
+			args:       []string{"list", "-config", filepath.Join(t.TempDir(), "missing.json")},
+			wantCode:   exitError,
+			wantStderr: "no config file at",
+		},
+		{
+			name:       "Unknown command",
+			args:       []string{"edit"},
+			wantCode:   exitUsage,
+			wantStderr: `unknown command "edit"`,
+		},
+	}
+
+	// The cases run in order against the same server, "Delete" removes the
+	// snippet created by "Create from stdin"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, tt.stdin, tt.args...)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, stdout, tt.wantStdout)
+			assert.StringContains(t, stderr, tt.wantStderr)
+		})
+	}
+}
+
+func TestCreateJSONOutput(t *testing.T) {
+	ts, api := newTestServer(t)
+	cfg := writeTestConfig(t, ts.URL, testToken)
+
+	code, stdout, _ := runCLI(t, "hello", "create", "-config", cfg, "-json", "-t", "Greeting", "-v", "private")
+	assert.Equal(t, code, exitOK)
+
+	var output struct {
+		Snippet snippet `json:"snippet"`
+		URL     string  `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, output.Snippet.Title, "Greeting")
+	assert.Equal(t, output.Snippet.Visibility, "private")
+	assert.Equal(t, output.URL, ts.URL+"/snippet/view/2")
+	assert.Equal(t, api.snippets[2].Content, "hello")
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		expiry  string
+		want    int
+		wantErr bool
+	}{
+		{expiry: "7d", want: 7},
+		{expiry: "1w", want: 7},
+		{expiry: "1y", want: 365},
+		{expiry: "365", want: 365},
+		{expiry: "0d", wantErr: true},
+		{expiry: "d", wantErr: true},
+		{expiry: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expiry, func(t *testing.T) {
+			days, err := parseExpiry(tt.expiry)
+
+			assert.Equal(t, err != nil, tt.wantErr)
+			assert.Equal(t, days, tt.want)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* The only token the fake server accepts */
+const testToken = "sbx_test"
+
+// A stand in for the snippetbox API, implementing just enough of
+// ui/api/openapi.json for the commands: snippets are kept in memory, snippet
+// 1 exists from the start and belongs to somebody else.
+type fakeAPI struct {
+	mu       sync.Mutex
+	snippets map[int]*snippet
+	nextID   int
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		snippets: map[int]*snippet{
+			1: {
+				ID:         1,
+				Title:      "An old silent pond",
+				Content:    "An old silent pond...\n",
+				Created:    time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+				Expires:    time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC),
+				Visibility: "public",
+				UserID:     2,
+			},
+		},
+		nextID: 2,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	authenticated := r.Header.Get("Authorization") == "Bearer "+testToken
+	if r.Header.Get("Authorization") != "" && !authenticated {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing authentication token"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/snippets")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		search := strings.ToLower(r.URL.Query().Get("q"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+
+		snippets := []*snippet{}
+		for id := f.nextID - 1; id > 0; id-- {
+			s, ok := f.snippets[id]
+			if ok && s.Visibility == "public" && strings.Contains(strings.ToLower(s.Title+s.Content), search) {
+				snippets = append(snippets, s)
+			}
+		}
+
+		start := min((page-1)*pageSize, len(snippets))
+		end := min(start+pageSize, len(snippets))
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"snippets": snippets[start:end],
+			"metadata": listMetadata{Page: page, PageSize: pageSize, HasMore: end < len(snippets)},
+		})
+	case path == "" && r.Method == http.MethodPost:
+		if !authenticated {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "you must be authenticated with an API token to access this resource"})
+			return
+		}
+
+		var input snippetInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		errs := map[string]string{}
+		if strings.TrimSpace(input.Content) == "" {
+			errs["content"] = "This field cannot be blank"
+		}
+		if input.Expires != 1 && input.Expires != 7 && input.Expires != 365 {
+			errs["expires"] = "This field must equal 1, 7 or 365."
+		}
+		if len(errs) > 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"errors": errs})
+			return
+		}
+
+		s := &snippet{
+			ID:         f.nextID,
+			Title:      input.Title,
+			Content:    input.Content,
+			Language:   input.Language,
+			Created:    time.Now().UTC(),
+			Expires:    time.Now().AddDate(0, 0, input.Expires).UTC(),
+			Visibility: input.Visibility,
+			UserID:     1,
+		}
+		f.snippets[s.ID] = s
+		f.nextID++
+
+		writeJSON(w, http.StatusCreated, map[string]any{"snippet": s})
+	default:
+		id, err := strconv.Atoi(strings.TrimPrefix(path, "/"))
+		s, ok := f.snippets[id]
+		if err != nil || !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "the requested resource could not be found"})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"snippet": s})
+		case http.MethodDelete:
+			if !authenticated {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "you must be authenticated with an API token to access this resource"})
+				return
+			}
+			if s.UserID != 1 {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "you do not own this snippet"})
+				return
+			}
+			delete(f.snippets, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+/* Writes a config file pointing at server and returns its path */
+func writeTestConfig(t *testing.T, server, token string) string {
+	js, err := json.Marshal(config{Server: server, Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, js, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+/* Runs the command with the given stdin, returning its exit code and output */
+func runCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+
+	c := &cli{
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	}
+
+	code := c.run(args)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *fakeAPI) {
+	api := newFakeAPI()
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+
+	return ts, api
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+/* Rows per page in the admin listings */
+const adminPageSize = 20
+
+func (app *application) adminHome(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+
+	app.render(w, r, http.StatusOK, "admin.tmpl.html", data)
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+	page := pageFromQuery(r)
+
+	users, err := app.users.List(r.Context(), search, adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Search = search
+	data.Page = page
+
+	app.render(w, r, http.StatusOK, "adminusers.tmpl.html", data)
+}
+
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.errorNotFound(w)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+	disabled := r.PostForm.Get("disabled") == "true"
+
+	/* Would lock the admin out with no way back in */
+	if id == app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+		app.sessionManager.Put(r.Context(), "flash", "You can not disable your own account.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.SetDisabled(r.Context(), id, disabled)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	if disabled {
+		err = app.logoutEverywhere(r, id)
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", "Account disabled.")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Account enabled.")
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.errorNotFound(w)
+		return
+	}
+
+	err := app.users.RequirePasswordReset(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	/* Logged out everywhere, they have to choose a new password however they log back in */
+	err = app.logoutEverywhere(r, id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The user has to choose a new password on their next login.")
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	page := pageFromQuery(r)
+
+	snippets, err := app.snippets.Browse(r.Context(), adminPageSize, (page-1)*adminPageSize)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Page = page
+
+	app.render(w, r, http.StatusOK, "adminsnippets.tmpl.html", data)
+}
+
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.errorNotFound(w)
+		return
+	}
+
+	/* INFO: Looked up first for the webhooks, Get does not return expired
+	   snippets so all that is known about those is the id */
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			app.errorServer(w, r, err)
+			return
+		}
+		snippet = &models.Snippet{ID: id}
+	}
+
+	err = app.snippets.Delete(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	app.queueWebhooks(r.Context(), models.EventSnippetDeleted, snippet)
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet deleted.")
+
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+/* Ends every session and "remember me" login of the user */
+func (app *application) logoutEverywhere(r *http.Request, userID int) error {
+	err := app.rememberTokens.DeleteAllForUser(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return app.destroyUserSessions(r.Context(), userID)
+}
+
+func idFromParams(r *http.Request) (int, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+/* The ?page= query parameter, 1 when missing or invalid */
+func pageFromQuery(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+
+	return page
+}
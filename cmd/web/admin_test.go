@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestAdminAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Unauthenticated",
+			urlPath:  "/admin/users",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "User",
+			email:    "bob@example.com",
+			urlPath:  "/admin/snippets",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Admin users",
+			email:    "alice@example.com",
+			urlPath:  "/admin/users?q=bob",
+			wantCode: http.StatusOK,
+			wantBody: "bob@example.com",
+		},
+		{
+			name:     "Admin snippets",
+			email:    "alice@example.com",
+			urlPath:  "/admin/snippets",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.email != "" {
+				ts.login(t, tt.email, "password")
+			}
+
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAdminActions(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "password")
+
+	_, _, body := ts.get(t, "/admin/users")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		form     url.Values
+		wantCode int
+	}{
+		{
+			name:     "Disable user",
+			urlPath:  "/admin/users/1/disable",
+			form:     url.Values{"disabled": {"true"}},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Disable unknown user",
+			urlPath:  "/admin/users/99/disable",
+			form:     url.Values{"disabled": {"true"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Force password reset",
+			urlPath:  "/admin/users/1/reset-password",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Delete snippet",
+			urlPath:  "/admin/snippets/1/delete",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Delete unknown snippet",
+			urlPath:  "/admin/snippets/2/delete",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			for k, v := range tt.form {
+				form[k] = v
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestPasswordResetRequired(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "carol@example.com", "password")
+
+	code, header, _ := ts.get(t, "/user/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/changepassword")
+
+	code, _, _ = ts.get(t, "/user/changepassword")
+	assert.Equal(t, code, http.StatusOK)
+}
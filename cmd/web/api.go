@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+/* Default and maximum number of snippets per page in API listings */
+const (
+	apiPageSize    = 20
+	apiMaxPageSize = 100
+)
+
+/* Largest request body the API accepts, in bytes */
+const apiMaxBodySize = 1_048_576
+
+type envelope map[string]any
+
+type snippetResponse struct {
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	Created        time.Time `json:"created"`
+	Expires        time.Time `json:"expires"`
+	Visibility     string    `json:"visibility"`
+	Language       string    `json:"language,omitempty"`
+	UserID         int       `json:"user_id,omitempty"`
+	OrganisationID int       `json:"organisation_id,omitempty"`
+}
+
+func newSnippetResponse(s *models.Snippet) snippetResponse {
+	return snippetResponse{
+		ID:             s.ID,
+		Title:          s.Title,
+		Content:        s.Content,
+		Created:        s.Created,
+		Expires:        s.Expires,
+		Visibility:     s.Visibility,
+		Language:       s.Language,
+		UserID:         s.UserID,
+		OrganisationID: s.OrganisationID,
+	}
+}
+
+// Body of a PATCH request, fields which are left out keep their current
+// value
+type snippetPatchRequest struct {
+	Title        *string `json:"title"`
+	Content      *string `json:"content"`
+	Language     *string `json:"language"`
+	Expires      *int    `json:"expires"`
+	Visibility   *string `json:"visibility"`
+	Organisation *int    `json:"organisation"`
+}
+
+func (app *application) apiSnippetsList(w http.ResponseWriter, r *http.Request) {
+	page := pageFromQuery(r)
+
+	var v validator.Validator
+
+	pageSize := apiPageSize
+	if s := r.URL.Query().Get("page_size"); s != "" {
+		var err error
+		pageSize, err = strconv.Atoi(s)
+		v.CheckField(err == nil && pageSize >= 1 && pageSize <= apiMaxPageSize, "page_size",
+			fmt.Sprintf("This field must be a number between 1 and %d.", apiMaxPageSize))
+	}
+
+	if !v.Valid() {
+		app.apiFailedValidation(w, v)
+		return
+	}
+
+	/* INFO: One extra row tells us whether there is another page */
+	search := r.URL.Query().Get("q")
+
+	snippets, err := app.snippets.Public(r.Context(), search, pageSize+1, (page-1)*pageSize)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	hasMore := len(snippets) > pageSize
+	if hasMore {
+		snippets = snippets[:pageSize]
+	}
+
+	response := []snippetResponse{}
+	for _, s := range snippets {
+		response = append(response, newSnippetResponse(s))
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{
+		"snippets": response,
+		"metadata": envelope{"page": page, "page_size": pageSize, "has_more": hasMore},
+	}, nil)
+}
+
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiSnippetFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"snippet": newSnippetResponse(snippet)}, nil)
+}
+
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	form := SnippetCreateForm{
+		Visibility: models.VisibilityPublic,
+	}
+
+	err := app.readJSON(w, r, &form)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := apiUserID(r)
+
+	organisations, err := app.organisations.ForUser(r.Context(), userID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365.")
+	validateSnippet(&form, organisations)
+
+	if !form.Valid() {
+		app.apiFailedValidation(w, form.Validator)
+		return
+	}
+
+	id, err := app.snippets.Insert(r.Context(), userID, form.Title, form.Content, form.Language, form.Expires, form.Visibility, form.Organisation)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	snippet := newSnippet(id, userID, &form)
+
+	app.metrics.snippetsCreated.WithLabelValues("api").Inc()
+	app.queueWebhooks(r.Context(), models.EventSnippetCreated, snippet)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/snippets/%d", id))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"snippet": newSnippetResponse(snippet)}, headers)
+}
+
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiEditableSnippetFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	var patch snippetPatchRequest
+
+	err := app.readJSON(w, r, &patch)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form := SnippetCreateForm{
+		Title:        snippet.Title,
+		Content:      snippet.Content,
+		Language:     snippet.Language,
+		Visibility:   snippet.Visibility,
+		Organisation: snippet.OrganisationID,
+	}
+
+	if patch.Title != nil {
+		form.Title = *patch.Title
+	}
+	if patch.Content != nil {
+		form.Content = *patch.Content
+	}
+	if patch.Language != nil {
+		form.Language = *patch.Language
+	}
+	if patch.Visibility != nil {
+		form.Visibility = *patch.Visibility
+	}
+	if patch.Organisation != nil {
+		form.Organisation = *patch.Organisation
+	}
+
+	expires := snippet.Expires
+	if patch.Expires != nil {
+		form.Expires = *patch.Expires
+		form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365.")
+		expires = time.Now().AddDate(0, 0, form.Expires).UTC()
+	}
+
+	/* Membership is checked for the editor, who may not be the author */
+	userID := apiUserID(r)
+
+	organisations, err := app.organisations.ForUser(r.Context(), userID)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	/* An organisation the editor has since left may stay as it is */
+	if form.Organisation != 0 && form.Organisation == snippet.OrganisationID {
+		organisations = append(organisations, &models.Organisation{ID: snippet.OrganisationID})
+	}
+
+	validateSnippet(&form, organisations)
+
+	if !form.Valid() {
+		app.apiFailedValidation(w, form.Validator)
+		return
+	}
+
+	err = app.snippets.Update(r.Context(), snippet.ID, form.Title, form.Content, form.Language, expires, form.Visibility, form.Organisation)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	updated := *snippet
+	updated.Title = form.Title
+	updated.Content = form.Content
+	updated.Language = form.Language
+	updated.Expires = expires
+	updated.Visibility = form.Visibility
+	updated.OrganisationID = form.Organisation
+
+	app.queueWebhooks(r.Context(), models.EventSnippetUpdated, &updated)
+
+	app.writeJSON(w, http.StatusOK, envelope{"snippet": newSnippetResponse(&updated)}, nil)
+}
+
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiEditableSnippetFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(r.Context(), snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	app.queueWebhooks(r.Context(), models.EventSnippetDeleted, snippet)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Looks up the snippet named in the url, responding with a 404 if it does
+// not exist or the caller may not view it. ok is false when a response has
+// already been written.
+func (app *application) apiSnippetFromParams(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.apiNotFound(w)
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	ok, err = app.canViewSnippet(r.Context(), apiUserID(r), snippet)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		app.apiNotFound(w)
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+/* Like apiSnippetFromParams, but a 403 if the caller may view but not edit it */
+func (app *application) apiEditableSnippetFromParams(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	snippet, ok := app.apiSnippetFromParams(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	ok, err := app.canEditSnippet(r.Context(), apiUserID(r), snippet)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		app.apiError(w, http.StatusForbidden, "you do not own this snippet")
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+/* The API has no sessions, only tokens. Zero for anonymous requests */
+func apiUserID(r *http.Request) int {
+	if token := apiTokenFromContext(r); token != nil {
+		return token.UserID
+	}
+
+	return 0
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		app.logger.Error(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// Decodes a single JSON object from the request body into dst. The errors
+// are meant to be shown to the client as they are.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			if unmarshalTypeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	if dec.More() {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+func (app *application) apiError(w http.ResponseWriter, status int, message string) {
+	app.writeJSON(w, status, envelope{"error": message}, nil)
+}
+
+func (app *application) apiNotFound(w http.ResponseWriter) {
+	app.apiError(w, http.StatusNotFound, "the requested resource could not be found")
+}
+
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	attrs := []any{
+		slog.String("request_id", requestIDFromContext(r)),
+		slog.String("method", r.Method),
+		slog.String("uri", r.URL.RequestURI()),
+	}
+
+	if status, ok := contextErrorStatus(err); ok {
+		app.logger.Warn(err.Error(), attrs...)
+		if status == statusClientClosedRequest {
+			w.WriteHeader(status)
+			return
+		}
+		app.apiError(w, status, "the server is busy, try again later")
+		return
+	}
+
+	app.logger.Error(err.Error(), attrs...)
+
+	app.apiError(w, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
+}
+
+/* Field errors in the same shape as validator.Validator.FieldErrors */
+func (app *application) apiFailedValidation(w http.ResponseWriter, v validator.Validator) {
+	app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"errors": v.FieldErrors}, nil)
+}
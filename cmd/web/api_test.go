@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestAPISnippets(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		urlPath      string
+		token        string
+		body         string
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:     "List",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets",
+			wantCode: http.StatusOK,
+			wantBody: `"title": "An old silent pond"`,
+		},
+		{
+			name:     "Search",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets?q=silent",
+			wantCode: http.StatusOK,
+			wantBody: `"title": "An old silent pond"`,
+		},
+		{
+			name:     "Search without results",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets?q=nothing",
+			wantCode: http.StatusOK,
+			wantBody: `"snippets": []`,
+		},
+		{
+			name:     "List with invalid page size",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets?page_size=1000",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"page_size": "This field must be a number between 1 and 100."`,
+		},
+		{
+			name:     "View",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/1",
+			wantCode: http.StatusOK,
+			wantBody: `"id": 1`,
+		},
+		{
+			name:     "View private snippet anonymously",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/3",
+			wantCode: http.StatusNotFound,
+			wantBody: `"error"`,
+		},
+		{
+			name:     "View private snippet with token",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/3",
+			token:    "sbx_read",
+			wantCode: http.StatusOK,
+			wantBody: `"visibility": "private"`,
+		},
+		{
+			name:     "Invalid token",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_invalid",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Token of a user who has to choose a new password",
+			method:   http.MethodGet,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_carol",
+			wantCode: http.StatusForbidden,
+			wantBody: "new password",
+		},
+		{
+			name:     "Create without token",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			body:     `{"title": "Title", "content": "Content", "expires": 7}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Create with read token",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_read",
+			body:     `{"title": "Title", "content": "Content", "expires": 7}`,
+			wantCode: http.StatusForbidden,
+			wantBody: "snippets:write",
+		},
+		{
+			name:         "Create",
+			method:       http.MethodPost,
+			urlPath:      "/api/v1/snippets",
+			token:        "sbx_write",
+			body:         `{"title": "Title", "content": "Content", "expires": 7}`,
+			wantCode:     http.StatusCreated,
+			wantBody:     `"visibility": "public"`,
+			wantLocation: "/api/v1/snippets/2",
+		},
+		{
+			name:     "Create with invalid fields",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_write",
+			body:     `{"title": "", "content": "Content", "expires": 2}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"title": "This field cannot be blank"`,
+		},
+		{
+			name:     "Create with team visibility outside an organisation",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_alice",
+			body:     `{"title": "Title", "content": "Content", "expires": 7, "visibility": "team", "organisation": 1}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"organisation": "You are not a member of this organisation."`,
+		},
+		{
+			name:     "Create with malformed JSON",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_write",
+			body:     `{"title": "Title"`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "badly-formed JSON",
+		},
+		{
+			name:     "Create with unknown field",
+			method:   http.MethodPost,
+			urlPath:  "/api/v1/snippets",
+			token:    "sbx_write",
+			body:     `{"title": "Title", "author": "bob"}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: `unknown field \"author\"`,
+		},
+		{
+			name:     "Update",
+			method:   http.MethodPatch,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_write",
+			body:     `{"title": "A new title"}`,
+			wantCode: http.StatusOK,
+			wantBody: `"title": "A new title"`,
+		},
+		{
+			name:     "Update with invalid visibility",
+			method:   http.MethodPatch,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_write",
+			body:     `{"visibility": "everyone"}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"visibility"`,
+		},
+		{
+			name:     "Update somebody elses snippet",
+			method:   http.MethodPatch,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_alice",
+			body:     `{"title": "Mine now"}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Delete",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/1",
+			token:    "sbx_write",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Delete non-existent snippet",
+			method:   http.MethodDelete,
+			urlPath:  "/api/v1/snippets/2",
+			token:    "sbx_write",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			code, rsHeader, body := ts.do(t, tt.method, tt.urlPath, header, strings.NewReader(tt.body))
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+			if tt.wantLocation != "" {
+				assert.Equal(t, rsHeader.Get("Location"), tt.wantLocation)
+			}
+
+			// Everything apart from an empty 204 has to be valid JSON
+			if code != http.StatusNoContent {
+				assert.Equal(t, rsHeader.Get("Content-Type"), "application/json")
+				assert.Equal(t, json.Valid([]byte(body)), true)
+			}
+		})
+	}
+}
@@ -5,3 +5,22 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+/* Set to true by authentication when an admin has asked the user to choose a new password */
+const passwordResetRequiredContextKey = contextKey("passwordResetRequired")
+
+/* Set to the *models.APIToken when a request was authenticated with a bearer token */
+const apiTokenContextKey = contextKey("apiToken")
+
+/* Set to the *requestInfo of every request by the requestID middleware */
+const requestInfoContextKey = contextKey("requestInfo")
+
+// What the access log needs to know about a request which is only found out
+// further down the chain. A pointer, so it can be filled in after
+// logRequest has passed the request on.
+type requestInfo struct {
+	id     string
+	userID int
+	/* The pattern of the matched route, eg. /snippet/view/:id */
+	route string
+}
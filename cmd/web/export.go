@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type exportSession struct {
+	Expires time.Time `json:"expires"`
+}
+
+type exportOrganisation struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	Role string `json:"role"`
+}
+
+/* Without the token itself, which is only ever stored as a hash */
+type exportAPIToken struct {
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitzero"`
+	Expires  time.Time `json:"expires,omitzero"`
+}
+
+/* Without the signing secret, an archive lying around must not let anyone forge deliveries */
+type exportWebhook struct {
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	AllSnippets bool      `json:"all_snippets"`
+	Created     time.Time `json:"created"`
+}
+
+// Builds a zip archive of everything the user owns: their profile, every
+// snippet including expired ones, their currently active sessions, the
+// organisations they are in, their API tokens and their webhooks. The raw
+// content of each snippet is also added as its own file.
+func (app *application) buildExport(ctx context.Context, userID int) ([]byte, error) {
+	user, err := app.users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	snippets, err := app.snippets.AllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []exportSession{}
+	err = app.sessionManager.Iterate(context.Background(), func(ctx context.Context) error {
+		if app.sessionManager.GetInt(ctx, "authenticatedUserID") == userID {
+			sessions = append(sessions, exportSession{Expires: app.sessionManager.Deadline(ctx)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	organisations := []exportOrganisation{}
+	memberships, err := app.organisations.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range memberships {
+		organisations = append(organisations, exportOrganisation{Name: o.Name, Slug: o.Slug, Role: o.Role})
+	}
+
+	apiTokens := []exportAPIToken{}
+	tokens, err := app.apiTokens.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		apiTokens = append(apiTokens, exportAPIToken{Name: t.Name, Scopes: t.Scopes, Created: t.Created, LastUsed: t.LastUsed, Expires: t.Expires})
+	}
+
+	webhooks := []exportWebhook{}
+	hooks, err := app.webhooks.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range hooks {
+		webhooks = append(webhooks, exportWebhook{URL: w.URL, Events: w.Events, AllSnippets: w.AllSnippets, Created: w.Created})
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	profile := map[string]any{
+		"id":       user.ID,
+		"name":     user.Name,
+		"username": user.Username,
+		"email":    user.Email,
+		"created":  user.Created,
+	}
+
+	files := map[string]any{
+		"profile.json":       profile,
+		"snippets.json":      snippets,
+		"sessions.json":      sessions,
+		"organisations.json": organisations,
+		"api_tokens.json":    apiTokens,
+		"webhooks.json":      webhooks,
+	}
+
+	for name, v := range files {
+		js, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		if err = writeZipFile(zw, name, js); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range snippets {
+		err = writeZipFile(zw, fmt.Sprintf("snippets/%d.txt", s.ID), []byte(s.Content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, content []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(content)
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestBuildExport(t *testing.T) {
+	app := newTestApplication(t)
+
+	data, err := app.buildExport(t.Context(), 1)
+	assert.NilError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NilError(t, err)
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NilError(t, err)
+
+		content, err := io.ReadAll(rc)
+		assert.NilError(t, err)
+		rc.Close()
+
+		files[f.Name] = string(content)
+	}
+
+	assert.StringContains(t, files["profile.json"], "bob@example.com")
+	assert.StringContains(t, files["snippets.json"], "An old silent pond")
+	assert.Equal(t, files["sessions.json"], "[]")
+	assert.Equal(t, files["snippets/1.txt"], "An old silent pond...")
+	assert.StringContains(t, files["organisations.json"], `"role": "owner"`)
+	assert.StringContains(t, files["api_tokens.json"], `"name": "read"`)
+	assert.StringContains(t, files["webhooks.json"], `"url": "https://example.com/hook"`)
+
+	// Secrets stay out of the archive
+	for name, content := range files {
+		if strings.Contains(content, "whsec_") {
+			t.Errorf("%s contains a webhook secret", name)
+		}
+	}
+}
+
+func TestExportRequest(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Bob has just had an export made, Alice has not
+	tests := []struct {
+		name      string
+		email     string
+		wantFlash string
+	}{
+		{
+			name:      "Too soon",
+			email:     "bob@example.com",
+			wantFlash: "You can request a new export an hour after the last one.",
+		},
+		{
+			name:      "Accepted",
+			email:     "alice@example.com",
+			wantFlash: "Your export is being prepared.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.login(t, tt.email, "password")
+
+			_, _, body := ts.get(t, "/user/account/export")
+
+			form := url.Values{}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.postForm(t, "/user/account/export", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/user/account/export")
+
+			_, _, body = ts.get(t, "/user/account/export")
+			assert.StringContains(t, body, tt.wantFlash)
+		})
+	}
+
+	app.wg.Wait()
+}
+
+func TestExportDownload(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Own export",
+			urlPath:  "/user/account/export/1",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Unknown export",
+			urlPath:  "/user/account/export/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/user/account/export/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, header.Get("Content-Type"), "application/zip")
+			}
+		})
+	}
+}
@@ -1,33 +1,50 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/snippetbox/internal/migrations"
 	"github.com/mohafarman/snippetbox/internal/models"
 	"github.com/mohafarman/snippetbox/internal/validator"
 )
 
 type SnippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"`
-	validator.Validator `form:"-"`
+	Title               string `form:"title" json:"title"`
+	Content             string `form:"content" json:"content"`
+	Language            string `form:"language" json:"language"`
+	Expires             int    `form:"expires" json:"expires"`
+	Visibility          string `form:"visibility" json:"visibility"`
+	Organisation        int    `form:"organisation" json:"organisation"`
+	validator.Validator `form:"-" json:"-"`
 }
 
 type userSignupForm struct {
 	Name                string `form:"name"`
+	Username            string `form:"username"`
 	Email               string `form:"email"`
 	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
+/* Usernames which would clash with routes or could be used to impersonate staff */
+var reservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "help", "staff",
+	"moderator", "snippetbox", "api", "static", "user", "users", "snippet",
+	"snippets", "about", "login", "logout", "signup", "account", "me", "null",
+}
+
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	RememberMe          bool   `form:"remember_me"`
 	validator.Validator `form:"-"`
 }
 
@@ -38,26 +55,72 @@ type userChangePasswordForm struct {
 	validator.Validator  `form:"-"`
 }
 
-func (app *application) ping(w http.ResponseWriter, r *http.Request) {
+type userEditAccountForm struct {
+	Name                string `form:"name"`
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type userDeleteAccountForm struct {
+	Password            string `form:"password"`
+	Snippets            string `form:"snippets"`
+	validator.Validator `form:"-"`
+}
+
+/* Liveness, the process is up and serving requests */
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+// Readiness, whether requests should be sent here. Not once shutdown has
+// started, nor when the database can not be reached or its schema is behind.
+// The reason is only logged, the response does not give anything away.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := app.db.WithTimeout(r.Context())
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		app.logger.Warn("not ready", slog.String("reason", err.Error()))
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	pending, err := migrations.Pending(app.db)
+	if err != nil {
+		app.logger.Warn("not ready", slog.String("reason", err.Error()))
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if len(pending) > 0 {
+		http.Error(w, "migrations pending", http.StatusServiceUnavailable)
+		return
+	}
+
 	w.Write([]byte("OK"))
 }
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippets.Latest()
+	snippets, err := app.snippets.Latest(r.Context())
 	if err != nil {
-		app.errorServer(w, err)
+		app.errorServer(w, r, err)
 		return
 	}
 
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
 
-	app.render(w, http.StatusOK, "home.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "home.tmpl.html", data)
 }
 
 func (app *application) about(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	app.render(w, http.StatusOK, "about.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "about.tmpl.html", data)
 }
 
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
@@ -70,30 +133,101 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippets.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.errorNotFound(w)
 		} else {
-			app.errorServer(w, err)
+			app.errorServer(w, r, err)
 		}
+		return
+	}
+
+	/* INFO: 404 rather than 403 so the snippet's existence is not given away */
+	ok, err := app.canViewSnippet(r.Context(), app.authenticatedUserID(r), snippet)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+	if !ok {
+		app.errorNotFound(w)
+		return
 	}
 
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
 
-	app.render(w, http.StatusOK, "view.tmpl.html", data)
+	if snippet.OrganisationID != 0 {
+		organisations, err := app.organisations.ForUser(r.Context(), app.authenticatedUserID(r))
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+
+		for _, o := range organisations {
+			if o.ID == snippet.OrganisationID {
+				data.Organisation = o
+			}
+		}
+	}
+
+	/* Anonymous snippets have no author to link to */
+	if snippet.UserID != 0 {
+		data.Author, err = app.users.Get(r.Context(), snippet.UserID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.errorServer(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, http.StatusOK, "view.tmpl.html", data)
+}
+
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	user, err := app.users.GetByUsername(r.Context(), params.ByName("username"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	snippets, err := app.snippets.ForUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Author = user
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "profile.tmpl.html", data)
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	organisations, err := app.organisations.ForUser(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	/* INFO: data.Form has to be initialized or it is nil and will cause a
 	   500 internal server error. Also good time to set default values */
 	data.Form = SnippetCreateForm{
-		Expires: 365,
+		Expires:    365,
+		Visibility: models.VisibilityPublic,
 	}
+	data.Organisations = organisations
 
-	app.render(w, http.StatusOK, "create.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
@@ -105,34 +239,85 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters.")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365.")
 
+	userID := app.authenticatedUserID(r)
+
+	organisations, err := app.organisations.ForUser(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	validateSnippet(&form, organisations)
+
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.tmpl.html", data)
+		data.Organisations = organisations
+		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl.html", data)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippets.Insert(r.Context(), userID, form.Title, form.Content, form.Language, form.Expires, form.Visibility, form.Organisation)
 	if err != nil {
-		app.errorServer(w, err)
+		app.errorServer(w, r, err)
 		return
 	}
 
+	app.metrics.snippetsCreated.WithLabelValues("web").Inc()
+	app.queueWebhooks(r.Context(), models.EventSnippetCreated, newSnippet(id, userID, &form))
+
 	app.sessionManager.Put(r.Context(), "flash", "Snippet succesfully created!")
 
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
+// The snippet as it was just inserted, without reading it back. Created and
+// Expires may be off from the stored ones by the time the insert took.
+func newSnippet(id, userID int, form *SnippetCreateForm) *models.Snippet {
+	return &models.Snippet{
+		ID:             id,
+		Title:          form.Title,
+		Content:        form.Content,
+		Language:       form.Language,
+		Created:        time.Now().UTC(),
+		Expires:        time.Now().AddDate(0, 0, form.Expires).UTC(),
+		UserID:         userID,
+		OrganisationID: form.Organisation,
+		Visibility:     form.Visibility,
+	}
+}
+
+// Checks the fields shared by the html form and the JSON API, apart from
+// expires which the API may leave out when updating. organisations are the
+// ones the author is a member of.
+func validateSnippet(form *SnippetCreateForm, organisations []*models.Organisation) {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters.")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Language, 32), "language", "This field cannot be more than 32 characters.")
+	form.CheckField(validator.Matches(form.Language, validator.LanguageRX), "language", "This field may only contain lowercase letters, digits and + # . -")
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityTeam, models.VisibilityPrivate),
+		"visibility", "This field must equal public, unlisted, team or private.")
+	form.CheckField(form.Visibility != models.VisibilityTeam || form.Organisation != 0, "organisation", "Choose the organisation whose members may view this snippet.")
+
+	if form.Organisation != 0 {
+		member := false
+		for _, o := range organisations {
+			if o.ID == form.Organisation {
+				member = true
+			}
+		}
+		form.CheckField(member, "organisation", "You are not a member of this organisation.")
+	}
+}
+
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userSignupForm{}
 
-	app.render(w, http.StatusOK, "signup.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "signup.tmpl.html", data)
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
@@ -145,29 +330,43 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Username), "username", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Username, 3), "username", "This field must be at least 3 characters long")
+	form.CheckField(validator.MaxChars(form.Username, 30), "username", "This field cannot be more than 30 characters.")
+	form.CheckField(validator.Matches(form.Username, validator.UsernameRX), "username", "This field may only contain lowercase letters, digits, - and _")
+	form.CheckField(!validator.PermittedValue(form.Username, reservedUsernames...), "username", "This username is reserved")
 	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
 	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email adress")
 	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+
+	err = app.passwordPolicy.Validate(&form.Validator, "password", form.Password, form.Name, form.Username, form.Email)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 		return
 	}
 
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	err = app.users.Insert(r.Context(), form.Name, form.Username, form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address already in use")
+		if errors.Is(err, models.ErrDuplicateEmail) || errors.Is(err, models.ErrDuplicateUsername) {
+			if errors.Is(err, models.ErrDuplicateUsername) {
+				form.AddFieldError("username", "Username already taken")
+			} else {
+				form.AddFieldError("email", "Email address already in use")
+			}
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 			return
 		} else {
-			app.errorServer(w, err)
+			app.errorServer(w, r, err)
 		}
 
 		return
@@ -181,7 +380,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "login.tmpl.html", data)
 }
 
 func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -201,32 +400,57 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
 		return
 	}
 
-	id, err = app.users.Authenticate(form.Email, form.Password)
+	id, err = app.users.Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
+		if errors.Is(err, models.ErrInvalidCredentials) || errors.Is(err, models.ErrAccountDisabled) {
+			if errors.Is(err, models.ErrAccountDisabled) {
+				app.metrics.logins.WithLabelValues("disabled").Inc()
+				form.AddNonFieldError("This account has been disabled")
+			} else {
+				app.metrics.logins.WithLabelValues("failure").Inc()
+				form.AddNonFieldError("Email or password is incorrect")
+			}
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
 		} else {
-			app.errorServer(w, err)
+			app.errorServer(w, r, err)
 		}
 		return
 	}
 
+	app.metrics.logins.WithLabelValues("success").Inc()
+
 	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.errorServer(w, err)
+		app.errorServer(w, r, err)
 		return
 	}
 
 	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
 
+	/* Each "remember me" login starts a new token family */
+	if form.RememberMe {
+		family, err := models.NewTokenFamily()
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+
+		token, err := app.rememberTokens.New(r.Context(), id, family, app.rememberLifetime)
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+
+		app.setRememberCookie(w, token)
+	}
+
 	redirect := app.sessionManager.PopString(r.Context(), "redirect")
 	if redirect != "" {
 		http.Redirect(w, r, redirect, http.StatusSeeOther)
@@ -238,24 +462,33 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	id := app.sessionManager.Get(r.Context(), "authenticatedUserID").(int)
-	user, err := app.users.Get(id)
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-			return
+		} else {
+			app.errorServer(w, r, err)
 		}
+		return
+	}
+
+	organisations, err := app.organisations.ForUser(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
 	}
 
 	data.User = user
+	data.Organisations = organisations
 
-	app.render(w, http.StatusOK, "account.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "account.tmpl.html", data)
 }
 
 func (app *application) changePasswordView(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userChangePasswordForm{}
-	app.render(w, http.StatusOK, "changepassword.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "changepassword.tmpl.html", data)
 }
 
 func (app *application) changePasswordPost(w http.ResponseWriter, r *http.Request) {
@@ -270,47 +503,355 @@ func (app *application) changePasswordPost(w http.ResponseWriter, r *http.Reques
 	form.CheckField(validator.NotBlank(form.Current_Password), "current_password", "This field cannot be blank")
 	form.CheckField(validator.MinChars(form.Current_Password, 8), "current_password", "This field must be at least 8 characters long")
 	form.CheckField(validator.NotBlank(form.New_Password), "new_password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.New_Password, 8), "new_password", "This field must be at least 8 characters long")
 	form.CheckField(validator.NotBlank(form.Confirm_New_Password), "confirm_new_password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Confirm_New_Password, 8), "confirm_new_password", "This field must be at least 8 characters long")
 	form.CheckField(form.New_Password == form.Confirm_New_Password, "confirm_new_password", "Passwords do not match")
 
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	err = app.passwordPolicy.Validate(&form.Validator, "new_password", form.New_Password, user.Name, user.Username, user.Email)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "changepassword.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "changepassword.tmpl.html", data)
 		return
 	}
 
-	id := app.sessionManager.Get(r.Context(), "authenticatedUserID").(int)
 	/* Compare passwords and Update db */
-	if ok, err := app.users.CompareAndUpdatePassword(id, form.Current_Password, form.New_Password); !ok {
+	if ok, err := app.users.CompareAndUpdatePassword(r.Context(), id, form.Current_Password, form.New_Password); !ok {
 		if errors.Is(err, models.ErrInvalidCredentials) {
 			data := app.newTemplateData(r)
 			form.AddFieldError("current_password", "Current password is incorrect")
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "changepassword.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "changepassword.tmpl.html", data)
 			return
 		} else {
-			app.errorServer(w, err)
+			app.errorServer(w, r, err)
 			return
 		}
 	}
 
+	/* A stolen session or remember me cookie must not outlive the old password */
+	err = app.rememberTokens.DeleteAllForUser(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	err = app.destroyOtherUserSessions(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	app.clearRememberCookie(w)
+
 	app.sessionManager.Put(r.Context(), "flash", "Password changed successfully!")
 
 	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
 }
 
+func (app *application) accountEdit(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userEditAccountForm{
+		Name:  user.Name,
+		Email: user.Email,
+	}
+	app.render(w, r, http.StatusOK, "editaccount.tmpl.html", data)
+}
+
+func (app *application) accountEditPost(w http.ResponseWriter, r *http.Request) {
+	var form userEditAccountForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters.")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email adress")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "editaccount.tmpl.html", data)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	flash := "Your account has been updated."
+
+	/* INFO: The new address has to be confirmed before it replaces the old
+	   one, the old address is told about the change in case the account has
+	   been taken over */
+	if form.Email != user.Email {
+		token, err := app.users.RequestEmailChange(r.Context(), id, form.Email)
+		if err != nil {
+			if errors.Is(err, models.ErrDuplicateEmail) {
+				form.AddFieldError("email", "Email address already in use")
+
+				data := app.newTemplateData(r)
+				data.Form = form
+				app.render(w, r, http.StatusUnprocessableEntity, "editaccount.tmpl.html", data)
+			} else {
+				app.errorServer(w, r, err)
+			}
+			return
+		}
+
+		link := app.absoluteURL("/user/account/email/confirm?token=" + url.QueryEscape(token))
+
+		app.sendEmail(form.Email, "Confirm your new email address",
+			fmt.Sprintf("Hi %s,\n\nPlease confirm your new Snippetbox email address within 24 hours by visiting:\n\n%s\n", user.Name, link))
+		app.sendEmail(user.Email, "Your email address is being changed",
+			fmt.Sprintf("Hi %s,\n\nA change of your Snippetbox email address to %s has been requested. If this was not you, change your password right away.\n", user.Name, form.Email))
+
+		flash = "Check your new email address for a confirmation link."
+	}
+
+	if form.Name != user.Name {
+		err = app.users.UpdateName(r.Context(), id, form.Name)
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) emailChangeConfirm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := app.users.ConfirmEmailChange(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.sessionManager.Put(r.Context(), "flash", "This confirmation link is invalid or has expired.")
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), "flash", "Email address already in use.")
+		default:
+			app.errorServer(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed.")
+
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userDeleteAccountForm{
+		Snippets: "delete",
+	}
+	app.render(w, r, http.StatusOK, "deleteaccount.tmpl.html", data)
+}
+
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form userDeleteAccountForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Snippets, "delete", "keep"), "snippets", "This field must equal delete or keep")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "deleteaccount.tmpl.html", data)
+		return
+	}
+
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err = app.users.Delete(r.Context(), id, form.Password, form.Snippets == "keep")
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "Password is incorrect")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "deleteaccount.tmpl.html", data)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	/* Log the user out everywhere, not only in this browser */
+	err = app.destroyUserSessions(r.Context(), id)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.clearRememberCookie(w)
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) exportView(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	export, err := app.exports.Latest(r.Context(), id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Export = export
+
+	app.render(w, r, http.StatusOK, "export.tmpl.html", data)
+}
+
+func (app *application) exportPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	id, err := app.exports.Insert(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecentExport) {
+			app.sessionManager.Put(r.Context(), "flash", "You can request a new export an hour after the last one.")
+			http.Redirect(w, r, "/user/account/export", http.StatusSeeOther)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	/* INFO: Large accounts can take a while, so the archive is built in the
+	   background and the user comes back to the export page for it */
+	app.background(func() {
+		/* The request is long gone by the time the export is done */
+		ctx := context.Background()
+
+		data, err := app.buildExport(ctx, userID)
+		if err != nil {
+			app.logger.Error(err.Error(), slog.Int("export_id", id))
+			err = app.exports.Fail(ctx, id)
+		} else {
+			err = app.exports.Complete(ctx, id, data)
+		}
+
+		if err != nil {
+			app.logger.Error(err.Error(), slog.Int("export_id", id))
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your export is being prepared.")
+
+	http.Redirect(w, r, "/user/account/export", http.StatusSeeOther)
+}
+
+func (app *application) exportDownload(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.errorNotFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	export, err := app.exports.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	if export.Status != models.ExportReady {
+		app.errorNotFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"snippetbox-export-%d.zip\"", export.ID))
+
+	w.Write(export.Data)
+}
+
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	err := app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.errorServer(w, err)
+		app.errorServer(w, r, err)
 		return
 	}
 
 	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
 
+	if cookie, err := r.Cookie(rememberCookieName); err == nil {
+		if selector, _, ok := models.ParseRememberToken(cookie.Value); ok {
+			err = app.rememberTokens.Revoke(r.Context(), selector)
+			if err != nil {
+				app.errorServer(w, r, err)
+				return
+			}
+		}
+		app.clearRememberCookie(w)
+	}
+
 	app.sessionManager.Put(r.Context(), "flash", "You've been successfully logged out")
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -2,10 +2,15 @@ package main
 
 import (
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+	"github.com/mohafarman/snippetbox/internal/migrations"
 )
 
 func TestPing(t *testing.T) {
@@ -22,6 +27,53 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, string(body), "OK")
 }
 
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/healthz")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, body, "OK")
+}
+
+func TestReadyz(t *testing.T) {
+	app := newTestApplication(t)
+
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "readyz.db"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+	app.db = db
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.StringContains(t, body, "migrations pending")
+
+	_, err = migrations.Up(db)
+	assert.NilError(t, err)
+
+	code, _, body = ts.get(t, "/readyz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, body, "OK")
+
+	app.shuttingDown.Store(true)
+
+	code, _, body = ts.get(t, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.StringContains(t, body, "shutting down")
+
+	app.shuttingDown.Store(false)
+	db.Close()
+
+	code, _, body = ts.get(t, "/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.StringContains(t, body, "database unavailable")
+}
+
 func TestSnippetView(t *testing.T) {
 	app := newTestApplication(t)
 
@@ -42,6 +94,12 @@ func TestSnippetView(t *testing.T) {
 			wantCode: http.StatusOK,
 			wantBody: "An old silent pond...",
 		},
+		{
+			name:     "Author link",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: "<a href='/u/bob'>Bob Jones</a>",
+		},
 		{
 			name:     "Non-existent ID",
 			urlPath:  "/snippet/view/2",
@@ -94,14 +152,16 @@ func TestUserSignup(t *testing.T) {
 
 	const (
 		validName     = "Bob"
+		validUsername = "bob"
 		validEmail    = "bob@example.com"
-		validPassword = "password"
+		validPassword = "vivid-otter-ladder-42"
 		formTag       = "<form action='/user/signup' method='POST' novalidate>"
 	)
 
 	tests := []struct {
 		name         string
 		userName     string
+		userUsername string
 		userEmail    string
 		userPassword string
 		csrfToken    string
@@ -112,11 +172,62 @@ func TestUserSignup(t *testing.T) {
 
 			name:         "Valid submission",
 			userName:     validName,
+			userUsername: validUsername,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
 			wantCode:     http.StatusSeeOther,
 		},
+		{
+			name:         "Invalid username characters",
+			userName:     validName,
+			userUsername: "Bob Jones",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Reserved username",
+			userName:     validName,
+			userUsername: "admin",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Weak password",
+			userName:     validName,
+			userUsername: validUsername,
+			userEmail:    validEmail,
+			userPassword: "password",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Breached password",
+			userName:     validName,
+			userUsername: validUsername,
+			userEmail:    validEmail,
+			userPassword: "pa$$word123",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Duplicate username",
+			userName:     validName,
+			userUsername: "dupe",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
 	}
 	/* See the book for the rest of the examples */
 
@@ -124,9 +235,10 @@ func TestUserSignup(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			form := url.Values{}
 			form.Add("name", tt.userName)
+			form.Add("username", tt.userUsername)
 			form.Add("email", tt.userEmail)
 			form.Add("password", tt.userPassword)
-			form.Add("csrf", tt.csrfToken)
+			form.Add("csrf_token", tt.csrfToken)
 
 			code, _, body := ts.postForm(t, "/user/signup", form)
 
@@ -198,3 +310,331 @@ func TestSnippetCreate(t *testing.T) {
 		})
 	}
 }
+
+func TestRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		cookie       string
+		wantCode     int
+		wantLocation string
+		wantRotated  bool
+	}{
+		{
+			name:        "Valid token",
+			cookie:      "selector:validator",
+			wantCode:    http.StatusOK,
+			wantRotated: true,
+		},
+		{
+			name:     "Token rotated by a concurrent request",
+			cookie:   "concurrent:validator",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:         "User who has to choose a new password",
+			cookie:       "carol:validator",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/changepassword",
+			wantRotated:  true,
+		},
+		{
+			name:         "Reused token",
+			cookie:       "rotated:validator",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+		{
+			name:         "Malformed token",
+			cookie:       "garbage",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts.Client().Jar = jar
+
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jar.SetCookies(u, []*http.Cookie{{Name: rememberCookieName, Value: tt.cookie}})
+
+			code, header, _ := ts.get(t, "/user/account")
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+
+			rotated := false
+			for _, c := range header.Values("Set-Cookie") {
+				if strings.HasPrefix(c, rememberCookieName+"=selector:validator") {
+					rotated = true
+				}
+			}
+			assert.Equal(t, rotated, tt.wantRotated)
+		})
+	}
+}
+
+func TestAccountDelete(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	_, _, body := ts.get(t, "/user/account/delete")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		password     string
+		snippets     string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:     "Invalid snippets choice",
+			password: "password",
+			snippets: "archive",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Wrong password",
+			password: "wrong",
+			snippets: "keep",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Valid submission",
+			password:     "password",
+			snippets:     "keep",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("snippets", tt.snippets)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, "/user/account/delete", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+
+	// The session has been destroyed, so the account page is off limits
+	code, header, _ := ts.get(t, "/user/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestChangePassword(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Bob logs in from a second browser first, with a cookie jar of its own
+	ts.login(t, "bob@example.com", "password")
+	otherJar := ts.Client().Jar
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+	ts.login(t, "bob@example.com", "password")
+
+	_, _, body := ts.get(t, "/user/changepassword")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("current_password", "password")
+	form.Add("new_password", "correct horse battery staple")
+	form.Add("confirm_new_password", "correct horse battery staple")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/user/changepassword", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/account")
+
+	// The browser which changed the password stays logged in
+	code, _, _ = ts.get(t, "/user/account")
+	assert.Equal(t, code, http.StatusOK)
+
+	// Every other one is logged out
+	ts.Client().Jar = otherJar
+	code, header, _ = ts.get(t, "/user/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestAccountEdit(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	_, _, body := ts.get(t, "/user/account/edit")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name       string
+		userName   string
+		userEmail  string
+		wantCode   int
+		wantBody   string
+		wantEmails int
+	}{
+		{
+			name:      "Blank name",
+			userName:  "",
+			userEmail: "bob@example.com",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field cannot be blank",
+		},
+		{
+			name:      "Duplicate email",
+			userName:  "Bob Jones",
+			userEmail: "dupe@example.com",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "Email address already in use",
+		},
+		{
+			name:      "Name only",
+			userName:  "Robert Jones",
+			userEmail: "bob@example.com",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:       "New email",
+			userName:   "Bob Jones",
+			userEmail:  "robert@example.com",
+			wantCode:   http.StatusSeeOther,
+			wantEmails: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailer := &testMailer{}
+			app.mailer = mailer
+
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("email", tt.userEmail)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/account/edit", form)
+			app.wg.Wait()
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+
+			assert.Equal(t, len(mailer.sent), tt.wantEmails)
+			for _, email := range mailer.sent {
+				// Only the new address gets the confirmation link
+				if email.recipient == tt.userEmail {
+					assert.StringContains(t, email.body, "https://snippetbox.example/user/account/email/confirm?token=token")
+				} else {
+					assert.Equal(t, email.recipient, "bob@example.com")
+				}
+			}
+		})
+	}
+}
+
+func TestEmailChangeConfirm(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		token        string
+		wantLocation string
+	}{
+		{
+			name:         "Valid token",
+			token:        "token",
+			wantLocation: "/user/account",
+		},
+		{
+			name:         "Invalid token",
+			token:        "foo",
+			wantLocation: "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, "/user/account/email/confirm?token="+tt.token)
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Existing user",
+			urlPath:  "/u/bob",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Unknown user",
+			urlPath:  "/u/alice",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
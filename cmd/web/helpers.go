@@ -2,22 +2,65 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/go-playground/form/v4"
+	"github.com/mohafarman/snippetbox/internal/models"
 )
 
-func (app *application) errorServer(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+const rememberCookieName = "remember_token"
+
+// nginx's status for a request the client gave up on, there is none in
+// net/http. The client never sees it, it is only there for the logs.
+const statusClientClosedRequest = 499
+
+// The status for an error from a cancelled or timed out request context,
+// 499 when the client went away and 503 when a query took longer than the
+// query timeout. Neither is a bug, so they get no stack trace.
+func contextErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest, true
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, true
+	default:
+		return 0, false
+	}
+}
+
+func (app *application) errorServer(w http.ResponseWriter, r *http.Request, err error) {
+	attrs := []any{
+		slog.String("request_id", requestIDFromContext(r)),
+		slog.String("method", r.Method),
+		slog.String("uri", r.URL.RequestURI()),
+	}
+
+	if status, ok := contextErrorStatus(err); ok {
+		app.logger.Warn(err.Error(), attrs...)
+		if status == statusClientClosedRequest {
+			w.WriteHeader(status)
+			return
+		}
+		app.errorClient(w, status)
+		return
+	}
+
+	trace := string(debug.Stack())
+	app.logger.Error(err.Error(), append(attrs, slog.String("trace", trace))...)
+
 	if app.debugMode {
-		http.Error(w, trace, http.StatusInternalServerError)
+		http.Error(w, err.Error()+"\n"+trace, http.StatusInternalServerError)
 	} else {
-		app.errorLog.Output(2, trace)
-
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
@@ -35,17 +78,22 @@ func (app *application) errorDebugMode(w http.ResponseWriter, err error) {
 	w.Write([]byte(trace))
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 	ts, ok := app.templates[page]
 	if !ok {
 		err := fmt.Errorf("The template %s does not exit", page)
-		app.errorServer(w, err)
+		app.errorServer(w, r, err)
+		return
 	}
 
+	_, span := tracer().Start(r.Context(), "render "+page)
+	start := time.Now()
 	buf := new(bytes.Buffer)
 	err := ts.ExecuteTemplate(buf, "base", data)
+	app.metrics.renderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
+	span.End()
 	if err != nil {
-		app.errorServer(w, err)
+		app.errorServer(w, r, err)
 		return
 		/* Stop execution if there's an error with the template */
 	}
@@ -56,6 +104,49 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 
 }
 
+// The address of the client, without the port. Behind one of the trusted
+// proxies it is taken from X-Forwarded-For, walking it from the right and
+// skipping the trusted proxies, since everything to the left of them can be
+// made up by the client. Anyone else's X-Forwarded-For is ignored.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !app.trustedProxy(addr) {
+		return host
+	}
+
+	/* INFO: Every proxy appends to the header, or adds one of its own */
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		addr = next
+		if !app.trustedProxy(addr) {
+			break
+		}
+	}
+
+	return addr.Unmap().String()
+}
+
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 	err := r.ParseForm()
 	if err != nil {
@@ -78,6 +169,291 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+func (app *application) setRememberCookie(w http.ResponseWriter, token *models.RememberToken) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    token.String(),
+		Path:     "/",
+		Expires:  token.Expires,
+		MaxAge:   int(time.Until(token.Expires).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (app *application) clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Logs the user back in from their "remember me" cookie once the session has
+// expired. The used token is rotated into a new one from the same family,
+// unless a concurrent request with the same cookie has just done so.
+// Returns 0 if there is no usable cookie.
+func (app *application) loginFromRememberCookie(w http.ResponseWriter, r *http.Request) (int, error) {
+	cookie, err := r.Cookie(rememberCookieName)
+	if err != nil {
+		return 0, nil
+	}
+
+	selector, validator, ok := models.ParseRememberToken(cookie.Value)
+	if !ok {
+		app.clearRememberCookie(w)
+		return 0, nil
+	}
+
+	token, err := app.rememberTokens.Consume(r.Context(), selector, validator)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTokenReused):
+			app.logger.Warn("remember token reused, token family revoked",
+				slog.String("request_id", requestIDFromContext(r)),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("client_ip", app.clientIP(r)))
+			fallthrough
+		case errors.Is(err, models.ErrNoRecord), errors.Is(err, models.ErrInvalidCredentials):
+			app.clearRememberCookie(w)
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return 0, err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", token.UserID)
+
+	/* INFO: A concurrent request rotated it and sets the cookie for the new token */
+	if token.Rotated {
+		return token.UserID, nil
+	}
+
+	newToken, err := app.rememberTokens.New(r.Context(), token.UserID, token.Family, app.rememberLifetime)
+	if err != nil {
+		return 0, err
+	}
+
+	app.setRememberCookie(w, newToken)
+
+	return token.UserID, nil
+}
+
+// Destroys every stored session which belongs to the user. Needs a session
+// store which supports iteration, which sqlite3store, postgresstore and
+// memstore all do.
+func (app *application) destroyUserSessions(ctx context.Context, userID int) error {
+	return app.sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if app.sessionManager.GetInt(ctx, "authenticatedUserID") != userID {
+			return nil
+		}
+
+		return app.sessionManager.Destroy(ctx)
+	})
+}
+
+// Like destroyUserSessions, but keeps the session of the current request, so
+// that eg. changing the password only logs the user out everywhere else.
+func (app *application) destroyOtherUserSessions(ctx context.Context, userID int) error {
+	current := app.sessionManager.Token(ctx)
+
+	/* INFO: Iterate hands fn a context of its own for each stored session */
+	return app.sessionManager.Iterate(ctx, func(sessionCtx context.Context) error {
+		if app.sessionManager.Token(sessionCtx) == current || app.sessionManager.GetInt(sessionCtx, "authenticatedUserID") != userID {
+			return nil
+		}
+
+		return app.sessionManager.Destroy(sessionCtx)
+	})
+}
+
+// Runs fn in a new goroutine, a panic is logged rather than taking down the
+// whole server since recoverPanic only covers the request goroutine
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprint(err), slog.String("trace", string(debug.Stack())))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// A link to path on the site which works from anywhere, eg. in an email. It
+// starts with the configured base URL rather than the Host of the request,
+// which the client is free to make up.
+func (app *application) absoluteURL(path string) string {
+	return app.baseURL + path
+}
+
+/* Sends the email in the background, failures are only logged */
+func (app *application) sendEmail(recipient, subject, body string) {
+	app.background(func() {
+		err := app.mailer.Send(recipient, subject, body)
+		if err != nil {
+			app.logger.Error(err.Error(), slog.String("recipient", recipient))
+		}
+	})
+}
+
+var errPasswordResetRequired = errors.New("an admin has asked the user to choose a new password")
+
+// Looks up the API token in the requests Authorization header. Returns a nil
+// token and error if there is no header at all, and ErrInvalidCredentials if
+// the header is malformed or the token is unknown or expired.
+// errPasswordResetRequired means the token is fine, but its user has to
+// choose a new password before it can be used.
+func (app *application) bearerToken(r *http.Request) (*models.APIToken, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+
+	plaintext, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	token, err := app.apiTokens.Authenticate(r.Context(), strings.TrimSpace(plaintext))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.users.Get(r.Context(), token.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, models.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	/* INFO: Checked on every request, so an admin forcing a reset locks out the users tokens too */
+	if user.PasswordResetRequired {
+		return nil, errPasswordResetRequired
+	}
+
+	return token, nil
+}
+
+/* Set by authentication, false for requests which are not logged in with a session */
+func passwordResetRequired(r *http.Request) bool {
+	required, _ := r.Context().Value(passwordResetRequiredContextKey).(bool)
+	return required
+}
+
+/* The token the request was authenticated with, nil for session logins */
+func apiTokenFromContext(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(apiTokenContextKey).(*models.APIToken)
+	return token
+}
+
+/* Set by the requestID middleware, empty for requests which did not pass it */
+func requestIDFromContext(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return info.id
+	}
+
+	return ""
+}
+
+/* Pattern of the route the request matched, empty when none did */
+func routeFromContext(r *http.Request) string {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return info.route
+	}
+
+	return ""
+}
+
+/* Records who made the request, for the access log */
+func setRequestUserID(r *http.Request, userID int) {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// ID of the current user, whether they are logged in with a session or an
+// API token. Handlers on routes which accept tokens have to use this rather
+// than reading the session.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	if token := apiTokenFromContext(r); token != nil {
+		return token.UserID
+	}
+
+	return app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+}
+
+// Whether the user may view the snippet, userID is zero for anonymous users.
+// Public and unlisted snippets are visible to everybody, team snippets only
+// to members of the snippets organisation and private snippets only to their
+// author.
+func (app *application) canViewSnippet(ctx context.Context, userID int, snippet *models.Snippet) (bool, error) {
+	switch snippet.Visibility {
+	case models.VisibilityPublic, models.VisibilityUnlisted:
+		return true, nil
+	case models.VisibilityPrivate:
+		return userID != 0 && userID == snippet.UserID, nil
+	case models.VisibilityTeam:
+		if userID == 0 {
+			return false, nil
+		}
+		if userID == snippet.UserID {
+			return true, nil
+		}
+
+		_, err := app.organisations.MemberRole(ctx, snippet.OrganisationID, userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+/* Authors may edit their snippets, as may the owners of the snippets organisation */
+func (app *application) canEditSnippet(ctx context.Context, userID int, snippet *models.Snippet) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+	if userID == snippet.UserID {
+		return true, nil
+	}
+	if snippet.OrganisationID == 0 {
+		return false, nil
+	}
+
+	role, err := app.organisations.MemberRole(ctx, snippet.OrganisationID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return role == models.OrganisationOwner, nil
+}
+
 type neuteredFS struct {
 	fs http.FileSystem
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestErrorServer(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{
+			name:     "Client went away",
+			err:      fmt.Errorf("Error DB.QueryRow: %w", context.Canceled),
+			wantCode: statusClientClosedRequest,
+		},
+		{
+			name:     "Query timed out",
+			err:      context.DeadlineExceeded,
+			wantCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:     "Anything else",
+			err:      errors.New("boom"),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			rr := httptest.NewRecorder()
+			app.errorServer(rr, r, tt.err)
+			assert.Equal(t, rr.Code, tt.wantCode)
+
+			rr = httptest.NewRecorder()
+			app.apiServerError(rr, r, tt.err)
+			assert.Equal(t, rr.Code, tt.wantCode)
+		})
+	}
+}
+
+func TestErrorServerRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.logger = newLogger(&buf, logConfig{Format: "text", Level: "info"})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.errorServer(w, r, errors.New("boom"))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-ID", "abc123")
+
+	rr := httptest.NewRecorder()
+	app.requestID(next).ServeHTTP(rr, r)
+
+	assert.Equal(t, rr.Code, http.StatusInternalServerError)
+	assert.StringContains(t, buf.String(), "msg=boom request_id=abc123")
+}
+
+func TestRenderMissingTemplate(t *testing.T) {
+	app := newTestApplication(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	rr := httptest.NewRecorder()
+	app.render(rr, r, http.StatusOK, "missing.tmpl.html", &templateData{})
+
+	assert.Equal(t, rr.Code, http.StatusInternalServerError)
+}
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+
+	var err error
+	app.trustedProxies, err = parseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "No proxy",
+			remoteAddr: "203.0.113.7:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Untrusted X-Forwarded-For",
+			remoteAddr: "203.0.113.7:1234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Trusted proxy",
+			remoteAddr: "10.1.2.3:1234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Made up by the client",
+			remoteAddr: "10.1.2.3:1234",
+			forwarded:  []string{"127.0.0.1, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.1.2.3:1234",
+			forwarded:  []string{"198.51.100.1, 192.168.1.1", "10.9.9.9"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Trusted proxy without X-Forwarded-For",
+			remoteAddr: "10.1.2.3:1234",
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "Garbage",
+			remoteAddr: "10.1.2.3:1234",
+			forwarded:  []string{"nonsense"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "IPv6",
+			remoteAddr: "[2001:db8::1]:1234",
+			want:       "2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			assert.Equal(t, app.clientIP(r), tt.want)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+func validLogLevel(level string) bool {
+	_, ok := logLevels[strings.ToLower(level)]
+	return ok
+}
+
+// The logger everything is logged with, as text or JSON lines depending on
+// the config. cfg has to have been validated.
+func newLogger(w io.Writer, cfg logConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: logLevels[strings.ToLower(cfg.Level)]}
+
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Wraps the ResponseWriter of a request to find out what was sent, for the
+// access log
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	/* Only the first call counts, like net/http */
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+/* Nothing written at all is an empty 200 to net/http */
+func (rw *responseRecorder) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+
+	return rw.status
+}
+
+/* For http.ResponseController, eg. to flush the response */
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
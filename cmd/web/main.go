@@ -1,76 +1,251 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"database/sql"
+	"errors"
 	"flag"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alexedwards/scs/postgresstore"
 	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mohafarman/snippetbox/internal/database"
+	"github.com/mohafarman/snippetbox/internal/mailer"
+	"github.com/mohafarman/snippetbox/internal/migrations"
 	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/passwords"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type application struct {
-	infoLog        *log.Logger
-	errorLog       *log.Logger
+	logger         *slog.Logger
+	metrics        *metrics
 	snippets       models.SnippetModelInterface
 	users          models.UserModelInterface
+	rememberTokens models.RememberTokenModelInterface
+	exports        models.ExportModelInterface
+	organisations  models.OrganisationModelInterface
+	apiTokens      models.APITokenModelInterface
+	webhooks       models.WebhookModelInterface
 	templates      map[string]*template.Template
 	form           *form.Decoder
 	sessionManager *scs.SessionManager
+	mailer         mailer.Sender
+	passwordPolicy *passwords.Policy
 	debugMode      bool
+	/* Whether /paste may be used without an API token */
+	pasteAnonymous bool
+	/* Largest request body /paste accepts, in bytes */
+	pasteMaxSize int64
+	pasteLimiter *rateLimiter
+	/* Used for webhook deliveries, see newWebhookClient() */
+	webhookClient *http.Client
+	/* How long a "remember me" login lasts after the session has expired */
+	rememberLifetime time.Duration
+	/* Tracks goroutines started with app.background() and the webhook worker */
+	wg sync.WaitGroup
+	/* Only for /readyz, everything else goes through the models */
+	db *database.DB
+	/* Reverse proxies whose X-Forwarded-For header is believed, see clientIP() */
+	trustedProxies []netip.Prefix
+	/* The configured base URL without a trailing slash, see absoluteURL() */
+	baseURL string
+	/* Strict-Transport-Security header sent with every response, none when empty */
+	hstsHeader string
+	/* Set once serve() has started shutting down, see readyz() */
+	shuttingDown atomic.Bool
 }
 
 func main() {
-	addr := flag.String("port", "4000", "HTTP server port adress.")
-	debug := flag.Bool("debug", false, "debug mode. (default \"false\")")
-	flag.Parse()
+	/* INFO: Replaced with the configured one as soon as the config is loaded */
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-	infoLog := log.New(os.Stdout, "INFO:\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR:\t", log.Ldate|log.Ltime|log.Lshortfile)
+	cfg, cmds, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if err = cfg.validate(); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	/* INFO: driver-specific parameter which instructs
-	our driver to convert SQL TIME and DATE fields to Go time.Time objects */
-	dsn := "snippetbox?parseTime=true"
-	db, err := openDB(dsn)
+	logger = newLogger(os.Stdout, cfg.Log)
+
+	/* INFO: os.Exit skips deferred calls, so it is only called once run()
+	   has closed the database and flushed the traces */
+	err = run(cfg, cmds, logger)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// Runs the command or the server the config asks for. Everything it opens is
+// closed again before it returns, whether or not there was an error.
+func run(cfg *config, cmds *commands, logger *slog.Logger) error {
+	if cmds.printConfig {
+		return cfg.print(os.Stdout)
+	}
+
+	/* INFO: The default DSN has the driver-specific parameter parseTime, which
+	instructs our driver to convert SQL TIME and DATE fields to Go time.Time objects */
+	db, err := database.Open(database.Dialect(cfg.Driver), cfg.DSN)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
+	db.QueryTimeout = cfg.QueryTimeout
+
+	if cmds.migrate != "" {
+		return migrateCommand(db, cmds.migrate, logger)
+	}
+
+	/* INFO: Pending migrations are always applied at startup, -migrate is
+	   for looking at or rolling back the schema by hand */
+	applied, err := migrations.Up(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		logger.Info("migrated", slog.String("migration", m.String()))
+	}
+
+	/* INFO: There is no admin to grant the role from the admin area yet when
+	   setting up, so the first one is made from the command line */
+	if cmds.promoteAdmin != "" {
+		users := &models.UserModel{DB: db}
+
+		user, err := users.GetByEmail(context.Background(), cmds.promoteAdmin)
+		if err != nil {
+			return err
+		}
+
+		err = users.SetRole(context.Background(), user.ID, models.RoleAdmin)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("promoted to admin", slog.String("email", user.Email))
+		return nil
+	}
+
 	templates, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err)
+		return err
+	}
+
+	shutdownTracing, err := setupTracing(cfg.Tracing, os.Stdout)
+	if err != nil {
+		return err
 	}
+	defer shutdownTracing(context.Background())
 
 	formDecoder := form.NewDecoder()
 
 	sessionsManager := scs.New()
-	sessionsManager.Store = sqlite3store.New(db)
-	sessionsManager.Lifetime = 12 * time.Hour
+	/* INFO: Both stores support iteration, see destroyUserSessions() */
+	if db.Dialect == database.Postgres {
+		sessionsManager.Store = postgresstore.New(db.DB)
+	} else {
+		sessionsManager.Store = sqlite3store.New(db.DB)
+	}
+	sessionsManager.Lifetime = cfg.Session.Lifetime
 	/* Cookie will only be sent by a users browser when there is an HTTPS connection */
 	sessionsManager.Cookie.Secure = true
 
+	/* INFO: Changing these is safe, existing hashes are upgraded the next time
+	   their user logs in */
+	hashParams := passwords.DefaultParams
+	hashParams.Memory = uint32(cfg.Password.Argon2Memory)
+	hashParams.Iterations = uint32(cfg.Password.Argon2Iterations)
+	hashParams.Parallelism = uint8(cfg.Password.Argon2Parallelism)
+
+	passwordRules := []passwords.Rule{
+		passwords.MinLength(cfg.Password.MinLength),
+		passwords.MinEntropy(cfg.Password.MinEntropy),
+	}
+	if cfg.Password.Breached != "" {
+		passwordRules = append(passwordRules, passwords.NotBreached(&passwords.BreachedFile{Path: cfg.Password.Breached}))
+	}
+
+	var sender mailer.Sender = &mailer.Log{Logger: slog.NewLogLogger(logger.Handler(), slog.LevelInfo)}
+	if cfg.SMTP.Host != "" {
+		sender = &mailer.SMTP{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.Sender,
+		}
+	}
+
+	/* INFO: Already checked by validate() */
+	trustedProxies, err := parseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	/* INFO: A registry of our own rather than the global default, so that
+	   only what is registered here is exported */
+	registry := prometheus.NewRegistry()
+	registerStoreMetrics(registry, db.DB, sessionsManager.Store.(scs.IterableStore))
+
 	app := &application{
-		infoLog:  infoLog,
-		errorLog: errorLog,
+		logger:  logger,
+		metrics: newMetrics(registry),
 		snippets: &models.SnippetModel{
 			DB: db,
 		},
 		users: &models.UserModel{
+			DB:     db,
+			Hasher: passwords.New(hashParams),
+		},
+		rememberTokens: &models.RememberTokenModel{
+			DB: db,
+		},
+		exports: &models.ExportModel{
+			DB: db,
+		},
+		organisations: &models.OrganisationModel{
+			DB: db,
+		},
+		apiTokens: &models.APITokenModel{
+			DB: db,
+		},
+		webhooks: &models.WebhookModel{
 			DB: db,
 		},
-		templates:      templates,
-		form:           formDecoder,
-		sessionManager: sessionsManager,
-		debugMode:      *debug,
+		templates:        templates,
+		form:             formDecoder,
+		sessionManager:   sessionsManager,
+		mailer:           sender,
+		passwordPolicy:   passwords.NewPolicy(passwordRules...),
+		debugMode:        cfg.Debug,
+		pasteAnonymous:   cfg.Paste.Anonymous,
+		pasteMaxSize:     cfg.Paste.MaxSize,
+		pasteLimiter:     newRateLimiter(cfg.Paste.Rate),
+		webhookClient:    newWebhookClient(cfg.Webhook.Timeout, cfg.Webhook.AllowPrivate),
+		rememberLifetime: cfg.Session.Remember,
+		db:               db,
+		trustedProxies:   trustedProxies,
+		baseURL:          strings.TrimSuffix(cfg.BaseURL, "/"),
+		hstsHeader:       cfg.TLS.hstsHeader(),
 	}
 
 	/* Curve preferences value, so that only elliptic curves with
@@ -84,28 +259,67 @@ func main() {
 		// MinVersion: tls.VersionTLS13,
 	}
 
-	server := http.Server{
-		Addr:         ":" + *addr,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		ErrorLog:     app.errorLog,
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:      app.routes(),
 		TLSConfig:    tlsConfig,
 	}
 
-	infoLog.Print("Listening on port " + *addr)
-	err = server.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Print("Server failed to run. Error: " + err.Error())
-}
+	listeners := []listener{
+		{
+			name:   "server",
+			server: server,
+			listen: func() error { return server.ListenAndServeTLS(cfg.TLS.Cert, cfg.TLS.Key) },
+		},
+	}
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
+	/* INFO: Session and CSRF cookies are Secure, so plain HTTP only ever
+	   sends people over to HTTPS */
+	if cfg.TLS.RedirectAddr != "" {
+		redirectServer := &http.Server{
+			Addr:         cfg.TLS.RedirectAddr,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			Handler:      httpsRedirect(cfg.Port),
+		}
+
+		listeners = append(listeners, listener{
+			name:   "redirect server",
+			server: redirectServer,
+			listen: redirectServer.ListenAndServe,
+		})
 	}
-	if err = db.Ping(); err != nil {
-		return nil, err
+
+	/* INFO: Plain HTTP, it is meant to be bound to an internal address */
+	if cfg.Metrics.Addr != "" {
+		metricsServer := &http.Server{
+			Addr:         cfg.Metrics.Addr,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			Handler:      metricsHandler(registry),
+		}
+
+		listeners = append(listeners, listener{
+			name:   "metrics server",
+			server: metricsServer,
+			listen: metricsServer.ListenAndServe,
+		})
 	}
-	return db, err
+
+	err = app.serve(listeners, cfg.Webhook.Interval, cfg.Server.DrainDelay, cfg.Server.ShutdownTimeout)
+	if err != nil {
+		return err
+	}
+
+	/* INFO: The deferred calls close the database and flush the traces */
+	logger.Info("stopped")
+	return nil
 }
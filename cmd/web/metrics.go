@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/* Route label of requests which did not match any route */
+const unmatchedRoute = "unmatched"
+
+// Everything the application itself reports to Prometheus. The database and
+// session store are added by registerStoreMetrics, they are not around in
+// the handler tests.
+type metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	renderDuration  *prometheus.HistogramVec
+	logins          *prometheus.CounterVec
+	snippetsCreated *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by route pattern.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "snippetbox",
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "snippetbox",
+			Name:      "template_render_duration_seconds",
+			Help:      "Time taken to execute page templates.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"page"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "logins_total",
+			Help:      "Login attempts with the login form, by result.",
+		}, []string{"result"}),
+		snippetsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "snippets_created_total",
+			Help:      "Snippets created, by where they were created from.",
+		}, []string{"source"}),
+	}
+
+	reg.MustRegister(m.requests, m.requestDuration, m.renderDuration, m.logins, m.snippetsCreated)
+
+	return m
+}
+
+// Registers the connection pool stats of db and the number of live sessions
+// in store, along with the usual Go runtime and process metrics.
+func registerStoreMetrics(reg prometheus.Registerer, db *sql.DB, store scs.IterableStore) {
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "snippetbox"),
+		&sessionCollector{store: store},
+	)
+}
+
+var sessionsDesc = prometheus.NewDesc("snippetbox_sessions", "Sessions which have not expired yet.", nil, nil)
+
+// Counts the sessions on every scrape. A Collector rather than a GaugeFunc,
+// so that a failed count shows up as a scrape error instead of a made up value.
+type sessionCollector struct {
+	store scs.IterableStore
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	sessions, err := c.store.All()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(sessionsDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(len(sessions)))
+}
+
+/* The handler of the metrics listener, only /metrics is served */
+func metricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// Counts and times every request by the route pattern it matched. Has to
+// come after requestID, which the pattern is passed back up in.
+func (app *application) measureRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		route := routeFromContext(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		app.metrics.requests.WithLabelValues(r.Method, route, strconv.Itoa(rw.statusCode())).Inc()
+		app.metrics.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// An httprouter.Router which records the pattern of the matched route for
+// measureRequest. Labelling by the raw path would give every snippet its own
+// time series.
+type patternRouter struct {
+	*httprouter.Router
+}
+
+func (router patternRouter) Handler(method, path string, handler http.Handler) {
+	router.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.route = path
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func (router patternRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	router.Handler(method, path, handler)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMeasureRequest(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/no/such/page")
+
+	requests := app.metrics.requests
+
+	/* Labelled by the pattern, not the ID */
+	assert.Equal(t, testutil.ToFloat64(requests.WithLabelValues(http.MethodGet, "/snippet/view/:id", "200")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(requests.WithLabelValues(http.MethodGet, "/snippet/view/:id", "404")), 2.0)
+	assert.Equal(t, testutil.ToFloat64(requests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")), 1.0)
+
+	assert.Equal(t, testutil.CollectAndCount(app.metrics.requestDuration), 2)
+	assert.Equal(t, testutil.CollectAndCount(app.metrics.renderDuration), 1)
+}
+
+func TestLoginMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	assert.Equal(t, testutil.ToFloat64(app.metrics.logins.WithLabelValues("success")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(app.metrics.logins.WithLabelValues("failure")), 0.0)
+}
+
+func TestSessionCollector(t *testing.T) {
+	store := memstore.New()
+	defer store.StopCleanup()
+
+	store.Commit("a", []byte("a"), time.Now().Add(time.Hour))
+	store.Commit("b", []byte("b"), time.Now().Add(time.Hour))
+	store.Commit("expired", []byte("c"), time.Now().Add(-time.Hour))
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&sessionCollector{store: store})
+
+	expected := `
+# HELP snippetbox_sessions Sessions which have not expired yet.
+# TYPE snippetbox_sessions gauge
+snippetbox_sessions 2
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "snippetbox_sessions")
+	assert.NilError(t, err)
+}
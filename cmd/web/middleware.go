@@ -2,14 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/mohafarman/snippetbox/internal/models"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func secureHeaders(next http.Handler) http.Handler {
+func (app *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		/* INFO: Browsers ignore it over plain HTTP, where anyone could have added it */
+		if app.hstsHeader != "" && r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", app.hstsHeader)
+		}
 		w.Header().Set("Content-Security-Policy",
 			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
@@ -25,10 +37,74 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+/* Longest X-Request-ID taken from a request, anything else gets a new one */
+const maxRequestIDLength = 128
+
+// Gives every request an ID, the one in its X-Request-ID header when a proxy
+// in front of us or the client has already set one. The ID is sent back in
+// the response and logged with everything about the request, so a user can
+// report it. Has to come first in the chain.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestInfoContextKey, &requestInfo{id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/* IDs end up in the logs, so only a few harmless characters are allowed */
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// The access log, one line per request once it has been served. Comes after
+// requestID and before recoverPanic, so that requests which panicked are
+// logged with their 500 as well.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.RequestURI)
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		attrs := []any{
+			slog.String("request_id", requestIDFromContext(r)),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("client_ip", app.clientIP(r)),
+			slog.String("proto", r.Proto),
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+			slog.Int("status", rw.statusCode()),
+			slog.Int("bytes", rw.bytes),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.userID))
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+
+		app.logger.Info("request", attrs...)
 	})
 }
 
@@ -42,7 +118,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				/* INFO: Tells the client that the connection is closed.
 				   Works with HTTP/2 as well. */
 				w.Header().Set("Connection", "close")
-				app.errorServer(w, fmt.Errorf("%s", err))
+				app.errorServer(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -60,6 +136,15 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 			return
 		}
 
+		// An admin has asked the user to pick a new password, nothing else is
+		// available until they have (apart from logging out)
+		if passwordResetRequired(r) &&
+			r.URL.Path != "/user/changepassword" && r.URL.Path != "/user/logout" {
+			app.sessionManager.Put(r.Context(), "flash", "Please choose a new password.")
+			http.Redirect(w, r, "/user/changepassword", http.StatusSeeOther)
+			return
+		}
+
 		// Otherwise set the "Cache-Control: no-store" header so that pages
 		// require authentication are not stored in the users browser cache (or
 		// other intermediary cache).
@@ -69,27 +154,209 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// Only lets users with at least the given role through, everyone else gets a
+// 403. Has to come after requireAuthentication in the chain.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+			user, err := app.users.Get(r.Context(), id)
+			if err != nil {
+				if errors.Is(err, models.ErrNoRecord) {
+					app.errorClient(w, http.StatusForbidden)
+				} else {
+					app.errorServer(w, r, err)
+				}
+				return
+			}
+
+			if !user.HasRole(role) {
+				app.errorClient(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authenticates requests which carry an "Authorization: Bearer" header with
+// one of the users API tokens. A request with a bad token is rejected outright
+// rather than falling back to the session. Has to come before noSurf in the
+// chain, which lets token authenticated requests through without a CSRF token.
+func (app *application) tokenAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := app.bearerToken(r)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrInvalidCredentials):
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				app.errorClient(w, http.StatusUnauthorized)
+			case errors.Is(err, errPasswordResetRequired):
+				app.errorClient(w, http.StatusForbidden)
+			default:
+				app.errorServer(w, r, err)
+			}
+			return
+		}
+
+		if token == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setRequestUserID(r, token.UserID)
+
+		ctx := context.WithValue(r.Context(), apiTokenContextKey, token)
+		ctx = context.WithValue(ctx, isAuthenticatedContextKey, true)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Token authenticated requests need the given scope, 403 otherwise. Requests
+// authenticated with a session are not affected.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := apiTokenFromContext(r)
+			if token != nil && !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				app.errorClient(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The API counterpart of tokenAuthentication, responding with JSON. Requests
+// without an Authorization header carry on anonymously.
+func (app *application) apiAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		token, err := app.bearerToken(r)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrInvalidCredentials):
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				app.apiError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+			case errors.Is(err, errPasswordResetRequired):
+				app.apiError(w, http.StatusForbidden, "a new password has to be chosen before the API can be used")
+			default:
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		if token != nil {
+			setRequestUserID(r, token.UserID)
+			r = r.WithContext(context.WithValue(r.Context(), apiTokenContextKey, token))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) apiRequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiTokenFromContext(r) == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiError(w, http.StatusUnauthorized, "you must be authenticated with an API token to access this resource")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+/* The API counterpart of requireScope */
+func (app *application) apiRequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := apiTokenFromContext(r)
+			if token != nil && !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				app.apiError(w, http.StatusForbidden, fmt.Sprintf("your token is missing the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Rate limits requests per user when authenticated with an API token, and
+// per client IP address otherwise, see clientIP(). Has to come after tokenAuthentication.
+func (app *application) rateLimit(limiter *rateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + app.clientIP(r)
+			if token := apiTokenFromContext(r); token != nil {
+				key = fmt.Sprintf("user:%d", token.UserID)
+			}
+
+			ok, wait := limiter.allow(key)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				app.errorClient(w, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) authentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Already authenticated by tokenAuthentication
+		if apiTokenFromContext(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-		// Id == 0 if there is no authenticatedUserID, then simply call next
-		// handler in the chain with the unchanged context in r
+		// Id == 0 if there is no authenticatedUserID, in which case the user
+		// may still have a "remember me" cookie to log them back in with
+		if id == 0 {
+			var err error
+			id, err = app.loginFromRememberCookie(w, r)
+			if err != nil {
+				app.errorServer(w, r, err)
+				return
+			}
+		}
+
+		// Still no user, simply call next handler in the chain with the
+		// unchanged context in r
 		if id == 0 {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		exists, err := app.users.Exists(id)
-		if err != nil {
-			app.errorServer(w, err)
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.errorServer(w, r, err)
 			return
 		}
 
 		// If a matching user is found in the db then copy the new request with
-		// the isAuthenticatedContextKey and assign it to r
-		if exists {
+		// the isAuthenticatedContextKey and assign it to r. Whether they have
+		// to choose a new password is read from the db on every request too,
+		// so it holds however they were logged in.
+		if err == nil && !user.Disabled {
+			setRequestUserID(r, id)
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, passwordResetRequiredContextKey, user.PasswordResetRequired)
 			r = r.WithContext(ctx)
+		} else {
+			// The user has been deleted or disabled since they logged in, so
+			// log them out rather than leaving a dangling ID in their session
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
 		}
 
 		next.ServeHTTP(w, r)
@@ -99,6 +366,11 @@ func (app *application) authentication(next http.Handler) http.Handler {
 // Create a NoSurf middleware function which uses a customized CSRF cookie
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// INFO: A bearer token is never sent by the browser on its own, so a
+	// request carrying a valid one can not be forged from another site
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return apiTokenFromContext(r) != nil
+	})
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
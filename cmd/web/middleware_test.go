@@ -2,25 +2,30 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/mohafarman/snippetbox/internal/assert"
 )
 
 func TestSecureHeaders(t *testing.T) {
+	app := newTestApplication(t)
+	app.hstsHeader = "max-age=31536000; includeSubDomains"
+
 	rr := httptest.NewRecorder()
 
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r := httptest.NewRequest(http.MethodGet, "https://snippetbox.example/", nil)
 
 	// mock http handler
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
 	})
 
-	secureHeaders(next).ServeHTTP(rr, r)
+	app.secureHeaders(next).ServeHTTP(rr, r)
 	rs := rr.Result()
 
 	expected := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
@@ -38,6 +43,9 @@ func TestSecureHeaders(t *testing.T) {
 	expected = "0"
 	assert.Equal(t, rs.Header.Get("X-XSS-Protection"), expected)
 
+	expected = "max-age=31536000; includeSubDomains"
+	assert.Equal(t, rs.Header.Get("Strict-Transport-Security"), expected)
+
 	assert.Equal(t, rs.StatusCode, http.StatusOK)
 
 	defer rs.Body.Close()
@@ -48,4 +56,102 @@ func TestSecureHeaders(t *testing.T) {
 	bytes.TrimSpace(body)
 
 	assert.Equal(t, string(body), "OK")
+
+	/* Only sent over HTTPS */
+	rr = httptest.NewRecorder()
+	app.secureHeaders(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://snippetbox.example/", nil))
+	assert.Equal(t, rr.Result().Header.Get("Strict-Transport-Security"), "")
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		header   string
+		wantKept bool
+	}{
+		{
+			name:     "Generated",
+			header:   "",
+			wantKept: false,
+		},
+		{
+			name:     "Propagated",
+			header:   "5f0c6a2e-8d1b-4c3e-9a7f-1b2c3d4e5f60",
+			wantKept: true,
+		},
+		{
+			name:     "Unsafe characters",
+			header:   "abc\ninjected=1",
+			wantKept: false,
+		},
+		{
+			name:     "Too long",
+			header:   strings.Repeat("a", maxRequestIDLength+1),
+			wantKept: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+
+			var seen string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestIDFromContext(r)
+			})
+
+			rr := httptest.NewRecorder()
+			app.requestID(next).ServeHTTP(rr, r)
+
+			id := rr.Header().Get("X-Request-ID")
+			assert.Equal(t, id, seen)
+			assert.Equal(t, validRequestID(id), true)
+			assert.Equal(t, id == tt.header, tt.wantKept)
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.logger = newLogger(&buf, logConfig{Format: "json", Level: "info"})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRequestUserID(r, 7)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/pot?fill=1", nil)
+	r.Header.Set("X-Request-ID", "abc123")
+
+	rr := httptest.NewRecorder()
+	app.requestID(app.logRequest(next)).ServeHTTP(rr, r)
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Method    string `json:"method"`
+		URI       string `json:"uri"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+		Duration  int64  `json:"duration"`
+		UserID    int    `json:"user_id"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	assert.NilError(t, err)
+
+	assert.Equal(t, entry.Msg, "request")
+	assert.Equal(t, entry.RequestID, "abc123")
+	assert.Equal(t, entry.Method, http.MethodGet)
+	assert.Equal(t, entry.URI, "/pot?fill=1")
+	assert.Equal(t, entry.Status, http.StatusTeapot)
+	assert.Equal(t, entry.Bytes, len("short and stout"))
+	assert.Equal(t, entry.UserID, 7)
 }
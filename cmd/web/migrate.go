@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+	"github.com/mohafarman/snippetbox/internal/migrations"
+)
+
+// Runs the -migrate command, one of:
+//
+//	up       apply every pending migration
+//	down     revert the most recently applied migration
+//	status   show the current version and any pending migrations
+//	<n>      apply or revert migrations until the schema is at version n
+func migrateCommand(db *database.DB, command string, logger *slog.Logger) error {
+	before, err := migrations.Current(db)
+	if err != nil {
+		return err
+	}
+
+	var done []migrations.Migration
+
+	switch command {
+	case "up":
+		done, err = migrations.Up(db)
+	case "down":
+		done, err = migrations.Down(db)
+	case "status":
+		return migrateStatus(db, logger)
+	default:
+		version, convErr := strconv.Atoi(command)
+		if convErr != nil || version < 0 {
+			return fmt.Errorf(`-migrate must be "up", "down", "status" or a version number, got %q`, command)
+		}
+		done, err = migrations.To(db, version)
+	}
+
+	/* INFO: Logged even on error, the migrations before the failed one stay applied */
+	for _, m := range done {
+		if m.Version > before {
+			logger.Info("migrated", slog.String("migration", m.String()))
+		} else {
+			logger.Info("reverted", slog.String("migration", m.String()))
+		}
+	}
+
+	return err
+}
+
+func migrateStatus(db *database.DB, logger *slog.Logger) error {
+	all, err := migrations.All(db.Dialect)
+	if err != nil {
+		return err
+	}
+
+	current, err := migrations.Current(db)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("schema version", slog.Int("version", current))
+
+	for _, m := range all {
+		if m.Version > current {
+			logger.Info("pending", slog.String("migration", m.String()))
+		}
+	}
+
+	return nil
+}
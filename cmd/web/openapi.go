@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mohafarman/snippetbox/ui"
+)
+
+/* Path of the OpenAPI document inside ui.Files */
+const openAPIFile = "api/openapi.json"
+
+// The parts of the OpenAPI document which are shown on the docs page. The
+// document itself is only ever written by hand, never generated.
+type openAPISpec struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	/* Path → method → operation, path items may also hold "parameters" */
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]struct {
+			Description string `json:"description"`
+		} `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Summary     string                `json:"summary"`
+	Description string                `json:"description"`
+	Security    []map[string][]string `json:"security"`
+	Parameters  []struct {
+		Name        string `json:"name"`
+		In          string `json:"in"`
+		Description string `json:"description"`
+	} `json:"parameters"`
+	Responses map[string]struct {
+		Ref         string `json:"$ref"`
+		Description string `json:"description"`
+	} `json:"responses"`
+}
+
+type apiDocs struct {
+	Title       string
+	Version     string
+	Description string
+	Operations  []apiDocsOperation
+}
+
+type apiDocsOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	/* Empty if no token is needed */
+	Scopes     []string
+	Anonymous  bool
+	Parameters []string
+	Responses  []apiDocsResponse
+}
+
+type apiDocsResponse struct {
+	Status      string
+	Description string
+}
+
+/* Order operations are listed in for each path */
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+func loadOpenAPISpec() (*openAPISpec, error) {
+	js, err := ui.Files.ReadFile(openAPIFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec openAPISpec
+	if err := json.Unmarshal(js, &spec); err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+func (app *application) apiSpec(w http.ResponseWriter, r *http.Request) {
+	js, err := ui.Files.ReadFile(openAPIFile)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+/* A browsable version of the OpenAPI document */
+func (app *application) apiDocs(w http.ResponseWriter, r *http.Request) {
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	docs, err := newAPIDocs(spec)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.APIDocs = docs
+
+	app.render(w, r, http.StatusOK, "apidocs.tmpl.html", data)
+}
+
+func newAPIDocs(spec *openAPISpec) (*apiDocs, error) {
+	docs := &apiDocs{
+		Title:       spec.Info.Title,
+		Version:     spec.Info.Version,
+		Description: spec.Info.Description,
+	}
+
+	base := ""
+	if len(spec.Servers) > 0 {
+		base = spec.Servers[0].URL
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		for _, method := range openAPIMethods {
+			raw, ok := spec.Paths[path][method]
+			if !ok {
+				continue
+			}
+
+			var op openAPIOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, err
+			}
+
+			doc := apiDocsOperation{
+				Method:      strings.ToUpper(method),
+				Path:        base + path,
+				Summary:     op.Summary,
+				Description: op.Description,
+			}
+
+			for _, requirement := range op.Security {
+				if len(requirement) == 0 {
+					doc.Anonymous = true
+				}
+				for _, scopes := range requirement {
+					doc.Scopes = append(doc.Scopes, scopes...)
+				}
+			}
+
+			for _, p := range op.Parameters {
+				doc.Parameters = append(doc.Parameters, p.Name+" ("+p.In+"): "+p.Description)
+			}
+
+			statuses := make([]string, 0, len(op.Responses))
+			for status := range op.Responses {
+				statuses = append(statuses, status)
+			}
+			sort.Strings(statuses)
+
+			for _, status := range statuses {
+				response := op.Responses[status]
+				description := response.Description
+				if name, ok := strings.CutPrefix(response.Ref, "#/components/responses/"); ok {
+					description = spec.Components.Responses[name].Description
+				}
+				doc.Responses = append(doc.Responses, apiDocsResponse{Status: status, Description: description})
+			}
+
+			docs.Operations = append(docs.Operations, doc)
+		}
+	}
+
+	return docs, nil
+}
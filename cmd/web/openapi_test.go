@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/ui"
+)
+
+// The OpenAPI document as plain JSON values, which is all the checks below
+// need
+func readOpenAPIDocument(t *testing.T) map[string]any {
+	js, err := ui.Files.ReadFile(openAPIFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(js, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+/* "/api/v1/snippets/:id" → "/snippets/{id}" */
+func openAPIPath(t *testing.T, doc map[string]any, routePath string) string {
+	server := doc["servers"].([]any)[0].(map[string]any)["url"].(string)
+
+	path, ok := strings.CutPrefix(routePath, server)
+	if !ok {
+		t.Fatalf("route %s is not under the server url %s", routePath, server)
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func openAPIOperationFor(doc map[string]any, path, method string) (map[string]any, bool) {
+	item, ok := doc["paths"].(map[string]any)[path].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	return op, ok
+}
+
+/* Follows a "$ref" within the document, values without one are returned as they are */
+func resolveRef(t *testing.T, doc map[string]any, v map[string]any) map[string]any {
+	ref, ok := v["$ref"].(string)
+	if !ok {
+		return v
+	}
+
+	var node any = doc
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node = node.(map[string]any)[key]
+	}
+
+	resolved, ok := node.(map[string]any)
+	if !ok {
+		t.Fatalf("unresolvable $ref %s", ref)
+	}
+
+	return resolved
+}
+
+// Checks value against the subset of JSON Schema used in the document:
+// $ref, type, enum, required, properties, additionalProperties, items,
+// maxLength and the date-time format
+func validateSchema(t *testing.T, doc map[string]any, schema map[string]any, value any, at string) error {
+	schema = resolveRef(t, doc, schema)
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", at, value)
+		}
+
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required property %q", at, name)
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]any)
+		for name, v := range object {
+			if property, ok := properties[name].(map[string]any); ok {
+				if err := validateSchema(t, doc, property, v, at+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: unexpected property %q", at, name)
+				}
+			case map[string]any:
+				if err := validateSchema(t, doc, additional, v, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", at, value)
+		}
+
+		items := schema["items"].(map[string]any)
+		for i, v := range array {
+			if err := validateSchema(t, doc, items, v, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %T", at, value)
+		}
+
+		if max, ok := schema["maxLength"].(float64); ok && utf8.RuneCountInString(s) > int(max) {
+			return fmt.Errorf("%s: longer than %d characters", at, int(max))
+		}
+
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, s)
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: expected an integer, got %v", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", at, value)
+		}
+	}
+
+	return nil
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	doc := readOpenAPIDocument(t)
+
+	routes := app.apiRoutes()
+
+	// Every route is described in the document...
+	described := map[string]bool{}
+	for _, route := range routes {
+		path := openAPIPath(t, doc, route.path)
+
+		_, ok := openAPIOperationFor(doc, path, route.method)
+		if !ok {
+			t.Errorf("%s %s is not described in %s", route.method, route.path, openAPIFile)
+		}
+
+		described[strings.ToLower(route.method)+" "+path] = true
+	}
+
+	// ...and nothing is described which does not exist
+	for path, item := range doc["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+			if !described[method+" "+path] {
+				t.Errorf("%s %s is described in %s but is not a route", strings.ToUpper(method), path, openAPIFile)
+			}
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	app := newTestApplication(t)
+	doc := readOpenAPIDocument(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// A sample of each kind of response from every operation
+	tests := []struct {
+		method   string
+		path     string
+		urlPath  string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{http.MethodGet, "/api/v1/snippets", "/api/v1/snippets", "", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/snippets", "/api/v1/snippets?page_size=0", "", "", http.StatusUnprocessableEntity},
+		{http.MethodGet, "/api/v1/snippets", "/api/v1/snippets", "sbx_invalid", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/v1/snippets", "/api/v1/snippets", "sbx_write", `{"title": "Title", "content": "Content", "expires": 7, "visibility": "team", "organisation": 1}`, http.StatusCreated},
+		{http.MethodPost, "/api/v1/snippets", "/api/v1/snippets", "sbx_write", `{"title": ""}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/api/v1/snippets", "/api/v1/snippets", "sbx_write", `[]`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/snippets", "/api/v1/snippets", "sbx_read", `{}`, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/snippets/:id", "/api/v1/snippets/1", "", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/snippets/:id", "/api/v1/snippets/4", "sbx_read", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/snippets/:id", "/api/v1/snippets/4", "", "", http.StatusNotFound},
+		{http.MethodPatch, "/api/v1/snippets/:id", "/api/v1/snippets/4", "sbx_write", `{"expires": 1}`, http.StatusOK},
+		{http.MethodPatch, "/api/v1/snippets/:id", "/api/v1/snippets/1", "sbx_alice", `{"title": "Title"}`, http.StatusForbidden},
+		{http.MethodDelete, "/api/v1/snippets/:id", "/api/v1/snippets/1", "sbx_write", "", http.StatusNoContent},
+		{http.MethodDelete, "/api/v1/snippets/:id", "/api/v1/snippets/1", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s %d", tt.method, tt.urlPath, tt.wantCode), func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			code, rsHeader, body := ts.do(t, tt.method, tt.urlPath, header, strings.NewReader(tt.body))
+			assert.Equal(t, code, tt.wantCode)
+
+			op, ok := openAPIOperationFor(doc, openAPIPath(t, doc, tt.path), tt.method)
+			if !ok {
+				t.Fatalf("%s %s is not described", tt.method, tt.path)
+			}
+
+			response, ok := op["responses"].(map[string]any)[fmt.Sprint(code)].(map[string]any)
+			if !ok {
+				t.Fatalf("status %d is not described", code)
+			}
+			response = resolveRef(t, doc, response)
+
+			content, ok := response["content"].(map[string]any)
+			if !ok {
+				assert.Equal(t, body, "")
+				return
+			}
+
+			assert.Equal(t, rsHeader.Get("Content-Type"), "application/json")
+
+			var value any
+			if err := json.Unmarshal([]byte(body), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			schema := content["application/json"].(map[string]any)["schema"].(map[string]any)
+			if err := validateSchema(t, doc, schema, value, "body"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAPIDocs(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/api/openapi.json")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.Equal(t, json.Valid([]byte(body)), true)
+
+	code, _, body = ts.get(t, "/api/docs")
+	assert.Equal(t, code, http.StatusOK)
+	for _, route := range app.apiRoutes() {
+		path := strings.ReplaceAll(route.path, ":id", "{id}")
+		assert.StringContains(t, body, route.method+" "+path)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+type orgCreateForm struct {
+	Name                string `form:"name"`
+	Slug                string `form:"slug"`
+	validator.Validator `form:"-"`
+}
+
+type orgInviteForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) orgCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = orgCreateForm{}
+
+	app.render(w, r, http.StatusOK, "orgcreate.tmpl.html", data)
+}
+
+func (app *application) orgCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form orgCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters.")
+	form.CheckField(validator.NotBlank(form.Slug), "slug", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Slug, 3), "slug", "This field must be at least 3 characters long")
+	form.CheckField(validator.MaxChars(form.Slug, 30), "slug", "This field cannot be more than 30 characters.")
+	form.CheckField(validator.Matches(form.Slug, validator.UsernameRX), "slug", "This field may only contain lowercase letters, digits, dashes and underscores")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "orgcreate.tmpl.html", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	_, err = app.organisations.Insert(r.Context(), form.Name, form.Slug, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateSlug) {
+			form.AddFieldError("slug", "This name is already taken")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "orgcreate.tmpl.html", data)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Organisation created!")
+
+	http.Redirect(w, r, "/org/view/"+form.Slug, http.StatusSeeOther)
+}
+
+/* The team dashboard, only visible to members */
+func (app *application) orgView(w http.ResponseWriter, r *http.Request) {
+	organisation, role, ok := app.organisationFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	snippets, err := app.snippets.ForOrganisation(r.Context(), organisation.ID, userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	members, err := app.organisations.Members(r.Context(), organisation.ID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	organisation.Role = role
+
+	data := app.newTemplateData(r)
+	data.Organisation = organisation
+	data.Members = members
+	data.Snippets = snippets
+	data.Form = orgInviteForm{}
+
+	app.render(w, r, http.StatusOK, "org.tmpl.html", data)
+}
+
+func (app *application) orgInvitePost(w http.ResponseWriter, r *http.Request) {
+	organisation, role, ok := app.organisationFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	if role != models.OrganisationOwner {
+		app.errorClient(w, http.StatusForbidden)
+		return
+	}
+
+	var form orgInviteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email adress")
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	if !form.Valid() {
+		snippets, err := app.snippets.ForOrganisation(r.Context(), organisation.ID, userID)
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+
+		members, err := app.organisations.Members(r.Context(), organisation.ID)
+		if err != nil {
+			app.errorServer(w, r, err)
+			return
+		}
+
+		organisation.Role = role
+
+		data := app.newTemplateData(r)
+		data.Organisation = organisation
+		data.Members = members
+		data.Snippets = snippets
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "org.tmpl.html", data)
+		return
+	}
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	token, err := app.organisations.Invite(r.Context(), organisation.ID, form.Email, userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	link := app.absoluteURL("/org/accept?token=" + url.QueryEscape(token))
+
+	app.sendEmail(form.Email, fmt.Sprintf("You have been invited to join %s", organisation.Name),
+		fmt.Sprintf("Hi,\n\n%s has invited you to join %s on Snippetbox. Sign in with this email address and accept the invitation within 7 days by visiting:\n\n%s\n", user.Name, organisation.Name, link))
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Invitation sent to %s.", form.Email))
+
+	http.Redirect(w, r, "/org/view/"+organisation.Slug, http.StatusSeeOther)
+}
+
+func (app *application) orgAccept(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	organisation, err := app.organisations.AcceptInvitation(r.Context(), token, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This invitation is invalid, has expired or was sent to another email address.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Welcome to %s!", organisation.Name))
+
+	http.Redirect(w, r, "/org/view/"+organisation.Slug, http.StatusSeeOther)
+}
+
+// Looks up the organisation named in the url along with the current users
+// role in it. Non members get a 404 so the existence of an organisation is
+// not given away; ok is false when a response has already been written.
+func (app *application) organisationFromParams(w http.ResponseWriter, r *http.Request) (*models.Organisation, string, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	organisation, err := app.organisations.GetBySlug(r.Context(), params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	role, err := app.organisations.MemberRole(r.Context(), organisation.ID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	return organisation, role, true
+}
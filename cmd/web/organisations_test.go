@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestSnippetVisibility(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		email    string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Private snippet as author",
+			email:    "bob@example.com",
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Private snippet as other user",
+			email:    "alice@example.com",
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Private snippet anonymously",
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Team snippet as member",
+			email:    "bob@example.com",
+			urlPath:  "/snippet/view/4",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Team snippet as non member",
+			email:    "alice@example.com",
+			urlPath:  "/snippet/view/4",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Team snippet anonymously",
+			urlPath:  "/snippet/view/4",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.email != "" {
+				ts.login(t, tt.email, "password")
+			}
+
+			code, _, _ := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestOrganisationView(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		email    string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Member",
+			email:    "bob@example.com",
+			urlPath:  "/org/view/acme",
+			wantCode: http.StatusOK,
+			wantBody: "A team note",
+		},
+		{
+			name:     "Non member",
+			email:    "alice@example.com",
+			urlPath:  "/org/view/acme",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non existent organisation",
+			email:    "bob@example.com",
+			urlPath:  "/org/view/missing",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, tt.email, "password")
+
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestOrganisationInvite(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name       string
+		email      string
+		invitee    string
+		wantCode   int
+		wantEmails int
+	}{
+		{
+			name:       "Owner",
+			email:      "bob@example.com",
+			invitee:    "carol@example.com",
+			wantCode:   http.StatusSeeOther,
+			wantEmails: 1,
+		},
+		{
+			name:     "Invalid email",
+			email:    "bob@example.com",
+			invitee:  "carol",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Non member",
+			email:    "alice@example.com",
+			invitee:  "carol@example.com",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailer := &testMailer{}
+			app.mailer = mailer
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, tt.email, "password")
+
+			_, _, body := ts.get(t, "/org/create")
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", tt.invitee)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/org/view/acme/invite", form)
+			app.wg.Wait()
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, len(mailer.sent), tt.wantEmails)
+			for _, email := range mailer.sent {
+				assert.Equal(t, email.recipient, tt.invitee)
+				assert.StringContains(t, email.body, "https://snippetbox.example/org/accept?token=invitation")
+			}
+		})
+	}
+}
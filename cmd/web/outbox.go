@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+const (
+	/* Deliveries are given up on after this many attempts, about 17 hours with webhookBackoff */
+	webhookMaxAttempts = 12
+	/* Deliveries sent at once per pass of the worker, so a pass takes about one timeout at most */
+	webhookBatchSize = 10
+)
+
+type webhookPayload struct {
+	Event   string         `json:"event"`
+	Created time.Time      `json:"created"`
+	Snippet webhookSnippet `json:"snippet"`
+}
+
+// The snippet as sent to webhooks. The content is left out, site wide
+// webhooks hear about every snippet including private ones.
+type webhookSnippet struct {
+	ID             int       `json:"id"`
+	Title          string    `json:"title"`
+	Language       string    `json:"language"`
+	Visibility     string    `json:"visibility"`
+	UserID         int       `json:"user_id,omitempty"`
+	OrganisationID int       `json:"organisation_id,omitempty"`
+	Created        time.Time `json:"created"`
+	Expires        time.Time `json:"expires"`
+	URL            string    `json:"url"`
+}
+
+// Puts event into the outbox of every webhook subscribed to it, for the
+// background worker to deliver. Failures are only logged, the snippet itself
+// has already been saved by the time this is called.
+func (app *application) queueWebhooks(ctx context.Context, event string, snippet *models.Snippet) {
+	/* INFO: The snippet is saved already, a client which goes away now must
+	   not lose its events */
+	ctx = context.WithoutCancel(ctx)
+
+	webhooks, err := app.webhooks.Subscribed(ctx, event, snippet.UserID)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload := webhookPayload{
+		Event:   event,
+		Created: time.Now().UTC(),
+		Snippet: webhookSnippet{
+			ID:             snippet.ID,
+			Title:          snippet.Title,
+			Language:       snippet.Language,
+			Visibility:     snippet.Visibility,
+			UserID:         snippet.UserID,
+			OrganisationID: snippet.OrganisationID,
+			Created:        snippet.Created,
+			Expires:        snippet.Expires,
+			URL:            app.absoluteURL(fmt.Sprintf("/snippet/view/%d", snippet.ID)),
+		},
+	}
+
+	js, err := json.Marshal(payload)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, w := range webhooks {
+		err = app.webhooks.Enqueue(ctx, w.ID, event, js)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+}
+
+// Queues events for expired snippets, delivers whatever is due in the outbox
+// and deletes what is no longer needed every interval, until ctx is cancelled. Not started with
+// app.background(), which is meant for work that finishes.
+func (app *application) webhookWorker(ctx context.Context, interval time.Duration) {
+	/* INFO: A pass which has started is finished on shutdown rather than
+	   cut off half way, serve() only waits so long for it */
+	work := context.WithoutCancel(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.queueExpiredSnippets(work)
+			app.deliverWebhooks(work)
+			app.cleanUp(work)
+		}
+	}
+}
+
+func (app *application) queueExpiredSnippets(ctx context.Context) {
+	snippets, err := app.snippets.TakeExpired(ctx)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, s := range snippets {
+		app.queueWebhooks(ctx, models.EventSnippetExpired, s)
+	}
+}
+
+/* Deletes old webhook deliveries, exports and remember tokens, failures are only logged */
+func (app *application) cleanUp(ctx context.Context) {
+	cleanups := []struct {
+		name   string
+		delete func(context.Context) (int, error)
+	}{
+		{"webhook deliveries", app.webhooks.Prune},
+		{"exports", app.exports.DeleteExpired},
+		{"remember tokens", app.rememberTokens.DeleteExpired},
+	}
+
+	for _, c := range cleanups {
+		n, err := c.delete(ctx)
+		if err != nil {
+			app.logger.Error(err.Error(), slog.String("cleanup", c.name))
+			continue
+		}
+
+		if n > 0 {
+			app.logger.Info("deleted "+c.name, slog.Int("count", n))
+		}
+	}
+}
+
+// One pass over the outbox. A batch of due deliveries is claimed, so that
+// the workers of other instances leave them alone, and sent all at once.
+func (app *application) deliverWebhooks(ctx context.Context) {
+	/* INFO: Long enough for every delivery of the batch to time out, after
+	   that the deliveries are taken again as their worker must have died */
+	lease := 2 * app.webhookClient.Timeout
+
+	deliveries, err := app.webhooks.Claim(ctx, webhookBatchSize, lease)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.deliverWebhook(ctx, d)
+		}()
+	}
+
+	wg.Wait()
+}
+
+/* Sends a claimed delivery and records how it went */
+func (app *application) deliverWebhook(ctx context.Context, d *models.WebhookDelivery) {
+	status, err := app.sendWebhook(d)
+
+	switch {
+	case err == nil:
+		err = app.webhooks.Delivered(ctx, d.ID, status)
+	case d.Attempts+1 >= webhookMaxAttempts:
+		err = app.webhooks.Fail(ctx, d.ID, status, err.Error())
+	default:
+		err = app.webhooks.Retry(ctx, d.ID, status, err.Error(), time.Now().Add(webhookBackoff(d.Attempts+1)))
+	}
+
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// POSTs the delivery to its webhook, any response other than a 2xx is an
+// error. status is zero if there was no response at all.
+func (app *application) sendWebhook(d *models.WebhookDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Snippetbox-Webhook")
+	req.Header.Set("X-Snippetbox-Event", d.Event)
+	req.Header.Set("X-Snippetbox-Delivery", strconv.Itoa(d.ID))
+	req.Header.Set("X-Snippetbox-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Snippetbox-Signature", signWebhook(d.Secret, timestamp, d.Payload))
+
+	resp, err := app.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	/* INFO: Drained so the connection can be reused. The body is never kept,
+	   the delivery log would otherwise show whatever the url answered with */
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Signature of a delivery, which receivers check by computing the same
+// HMAC-SHA256 with their copy of the secret:
+//
+//	sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Signing the timestamp lets receivers reject old deliveries being replayed.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/* Wait before the next attempt, doubling from 30 seconds up to 12 hours */
+func webhookBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < 12*time.Hour; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, 12*time.Hour)
+}
+
+var errWebhookAddress = errors.New("webhooks: address is not allowed")
+
+// Redirects are not followed, the url a delivery ends up at should be the
+// one the user registered. Unless allowPrivate is set, deliveries can only
+// go to public addresses.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if !allowPrivate {
+		dialer.Control = webhookDialControl
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	/* INFO: A proxy would be dialled instead of the webhook, which defeats the check */
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Refuses to connect to this machine or the internal network, otherwise any
+// user could have the server send requests to the metrics listener, a cloud
+// metadata endpoint and the like. It runs on the address actually being
+// dialled, after the host has been resolved, so a name which resolves to a
+// public address when the webhook is registered and a private one later on
+// is caught as well.
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+
+	if !publicAddr(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, ip)
+	}
+
+	return nil
+}
+
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
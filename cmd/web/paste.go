@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+/* Days until a paste expires when the request does not say */
+const pasteDefaultExpires = 7
+
+// Creates a snippet from a raw request body or a multipart upload and
+// responds with its url as plain text, eg.
+//
+//	dmesg | curl -F 'f=@-' https://snippetbox/paste
+//	curl --data-binary @main.go 'https://snippetbox/paste?language=go'
+//
+// title, expires and language may be given in the query string or as
+// multipart form fields. Pastes are unlisted, so they are reachable by their
+// link only. Everything is written as plain text, never through render().
+func (app *application) paste(w http.ResponseWriter, r *http.Request) {
+	token := apiTokenFromContext(r)
+	if token == nil && !app.pasteAnonymous {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "An API token with the snippets:write scope is required.", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.pasteMaxSize)
+
+	form, err := app.readPaste(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, fmt.Sprintf("Pastes must not be larger than %d bytes.", app.pasteMaxSize), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	validateSnippet(form, nil)
+	form.CheckField(utf8.ValidString(form.Content), "content", "This field must be UTF-8 encoded text")
+
+	if !form.Valid() {
+		fields := make([]string, 0, len(form.FieldErrors))
+		for field := range form.FieldErrors {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		var b strings.Builder
+		for _, field := range fields {
+			fmt.Fprintf(&b, "%s: %s\n", field, form.FieldErrors[field])
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(w, b.String())
+		return
+	}
+
+	userID := 0
+	if token != nil {
+		userID = token.UserID
+	}
+
+	id, err := app.snippets.Insert(r.Context(), userID, form.Title, form.Content, form.Language, form.Expires, form.Visibility, 0)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	app.metrics.snippetsCreated.WithLabelValues("paste").Inc()
+	app.queueWebhooks(r.Context(), models.EventSnippetCreated, newSnippet(id, userID, form))
+
+	url := app.absoluteURL(fmt.Sprintf("/snippet/view/%d", id))
+
+	w.Header().Set("Location", url)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, url+"\n")
+}
+
+// Reads the paste and its options from the request. Only the expires field
+// is validated here, since it has to be parsed.
+func (app *application) readPaste(r *http.Request) (*SnippetCreateForm, error) {
+	form := &SnippetCreateForm{
+		Title:      "Untitled paste",
+		Expires:    pasteDefaultExpires,
+		Visibility: models.VisibilityUnlisted,
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	/* Query string values win over form fields */
+	param := func(name string) string {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+		if r.MultipartForm != nil && len(r.MultipartForm.Value[name]) > 0 {
+			return r.MultipartForm.Value[name][0]
+		}
+		return ""
+	}
+
+	if mediaType == "multipart/form-data" {
+		err := r.ParseMultipartForm(app.pasteMaxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		content, filename, err := multipartPaste(r)
+		if err != nil {
+			return nil, err
+		}
+
+		form.Content = content
+		/* curl names stdin "-" */
+		if filename != "" && filename != "-" {
+			form.Title = filepath.Base(filename)
+		}
+	} else {
+		/* INFO: Everything else is the paste itself, including the
+		   application/x-www-form-urlencoded which curl --data-binary sends */
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		form.Content = string(content)
+	}
+
+	if title := param("title"); title != "" {
+		form.Title = title
+	}
+
+	form.Language = strings.ToLower(param("language"))
+
+	if expires := param("expires"); expires != "" {
+		days, err := strconv.Atoi(expires)
+		form.CheckField(err == nil && validator.PermittedValue(days, 1, 7, 365), "expires", "This field must equal 1, 7 or 365.")
+		form.Expires = days
+	}
+
+	return form, nil
+}
+
+// The paste from a multipart form: the one uploaded file, whatever its field
+// is called, or else the "content" field
+func multipartPaste(r *http.Request) (string, string, error) {
+	var files int
+	for _, headers := range r.MultipartForm.File {
+		files += len(headers)
+	}
+
+	if files > 1 {
+		return "", "", errors.New("only one file may be pasted at a time")
+	}
+
+	for _, headers := range r.MultipartForm.File {
+		f, err := headers[0].Open()
+		if err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return "", "", err
+		}
+
+		return string(content), headers[0].Filename, nil
+	}
+
+	if values := r.MultipartForm.Value["content"]; len(values) > 0 {
+		return values[0], "", nil
+	}
+
+	return "", "", nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestPaste(t *testing.T) {
+	multipartBody := func(filename, content string) (string, string) {
+		buf := new(bytes.Buffer)
+		mw := multipart.NewWriter(buf)
+
+		fw, err := mw.CreateFormFile("f", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+
+		mw.WriteField("language", "go")
+		mw.Close()
+
+		return mw.FormDataContentType(), buf.String()
+	}
+
+	fileType, fileBody := multipartBody("main.go", "package main")
+	stdinType, stdinBody := multipartBody("-", "[    0.000000] Linux version")
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		token       string
+		anonymous   bool
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:     "Anonymous when not allowed",
+			urlPath:  "/paste",
+			body:     "hello",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:      "Anonymous when allowed",
+			urlPath:   "/paste",
+			anonymous: true,
+			body:      "hello",
+			wantCode:  http.StatusCreated,
+			wantBody:  "https://snippetbox.example/snippet/view/2\n",
+		},
+		{
+			name:        "Raw body",
+			urlPath:     "/paste?title=Hello&expires=1&language=text",
+			token:       "sbx_write",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "hello=world",
+			wantCode:    http.StatusCreated,
+			wantBody:    "https://snippetbox.example/snippet/view/2\n",
+		},
+		{
+			name:        "Multipart file",
+			urlPath:     "/paste",
+			token:       "sbx_write",
+			contentType: fileType,
+			body:        fileBody,
+			wantCode:    http.StatusCreated,
+		},
+		{
+			name:        "Multipart stdin",
+			urlPath:     "/paste",
+			token:       "sbx_write",
+			contentType: stdinType,
+			body:        stdinBody,
+			wantCode:    http.StatusCreated,
+		},
+		{
+			name:     "Read only token",
+			urlPath:  "/paste",
+			token:    "sbx_read",
+			body:     "hello",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Empty",
+			urlPath:  "/paste",
+			token:    "sbx_write",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "content: This field cannot be blank",
+		},
+		{
+			name:     "Invalid options",
+			urlPath:  "/paste?expires=2&language=Go%20Lang",
+			token:    "sbx_write",
+			body:     "hello",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "expires: This field must equal 1, 7 or 365.\nlanguage:",
+		},
+		{
+			name:     "Not UTF-8",
+			urlPath:  "/paste",
+			token:    "sbx_write",
+			body:     "\xff\xfe",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "content: This field must be UTF-8 encoded text",
+		},
+		{
+			name:     "Too large",
+			urlPath:  "/paste",
+			token:    "sbx_write",
+			body:     strings.Repeat("a", 1025),
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.pasteAnonymous = tt.anonymous
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			header := http.Header{}
+			if tt.contentType != "" {
+				header.Set("Content-Type", tt.contentType)
+			}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			code, rsHeader, body := ts.do(t, http.MethodPost, tt.urlPath, header, strings.NewReader(tt.body))
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+
+			// Never an html page
+			assert.Equal(t, strings.HasPrefix(rsHeader.Get("Content-Type"), "text/plain"), true)
+		})
+	}
+}
+
+func TestPasteRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.pasteLimiter = newRateLimiter(1)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer sbx_write")
+
+	code, _, _ := ts.do(t, http.MethodPost, "/paste", header, strings.NewReader("first"))
+	assert.Equal(t, code, http.StatusCreated)
+
+	code, rsHeader, _ := ts.do(t, http.MethodPost, "/paste", header, strings.NewReader("second"))
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, rsHeader.Get("Retry-After"), "60")
+}
+
+func TestPasteRateLimitBehindProxy(t *testing.T) {
+	app := newTestApplication(t)
+	app.pasteAnonymous = true
+	app.pasteLimiter = newRateLimiter(1)
+
+	/* The test server is the proxy */
+	var err error
+	app.trustedProxies, err = parseTrustedProxies("127.0.0.1, ::1")
+	assert.NilError(t, err)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	paste := func(clientIP string) int {
+		header := http.Header{}
+		header.Set("X-Forwarded-For", clientIP)
+		code, _, _ := ts.do(t, http.MethodPost, "/paste", header, strings.NewReader("text"))
+		return code
+	}
+
+	assert.Equal(t, paste("198.51.100.1"), http.StatusCreated)
+	assert.Equal(t, paste("198.51.100.1"), http.StatusTooManyRequests)
+
+	// Every client behind the proxy has a bucket of its own
+	assert.Equal(t, paste("198.51.100.2"), http.StatusCreated)
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+
+	l := newRateLimiter(60)
+	l.now = func() time.Time { return now }
+
+	// The whole burst may be used at once
+	for i := 0; i < 60; i++ {
+		ok, _ := l.allow("a")
+		assert.Equal(t, ok, true)
+	}
+
+	ok, wait := l.allow("a")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, wait, time.Second)
+
+	// Clients have their own buckets
+	ok, _ = l.allow("b")
+	assert.Equal(t, ok, true)
+
+	// One request a second after that
+	now = now.Add(time.Second)
+	ok, _ = l.allow("a")
+	assert.Equal(t, ok, true)
+	ok, _ = l.allow("a")
+	assert.Equal(t, ok, false)
+
+	// Full buckets are swept away
+	now = now.Add(2 * time.Minute)
+	l.allow("c")
+	assert.Equal(t, len(l.clients), 1)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// A token bucket per client. Each client may make burst requests at once,
+// after which they get rate requests per second. Buckets which have filled
+// up again are swept away now and then so the map does not grow forever.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	clients   map[string]*rateBucket
+	lastSweep time.Time
+	/* Replaced in tests */
+	now func() time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+/* perMinute requests a minute per client, all of which may be used at once */
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(perMinute),
+		clients:   map[string]*rateBucket{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Takes a token from the clients bucket. If there are none left it returns
+// false and how long until there will be one.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+
+	b, ok := l.clients[key]
+	if !ok {
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.clients[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+/* Drops the buckets which would be full by now, they are the same as no bucket */
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, key)
+		}
+	}
+
+	l.lastSweep = now
+}
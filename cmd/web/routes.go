@@ -5,11 +5,12 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
+	"github.com/mohafarman/snippetbox/internal/models"
 	"github.com/mohafarman/snippetbox/ui"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := patternRouter{httprouter.New()}
 
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.errorNotFound(w)
@@ -20,7 +21,10 @@ func (app *application) routes() http.Handler {
 	// fs := http.FileServer(neuteredFS{http.Dir("./ui/static/")})
 	// router.Handler(http.MethodGet, "/static/*filepath", http.StripPrefix("/static", fs))
 
-	router.HandlerFunc(http.MethodGet, "/ping", app.ping)
+	router.HandlerFunc(http.MethodGet, "/healthz", app.healthz)
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readyz)
+	/* INFO: Older name of /healthz, kept for monitors which still use it */
+	router.HandlerFunc(http.MethodGet, "/ping", app.healthz)
 
 	fs := http.FileServer(http.FS(ui.Files))
 	// INFO: No need for strip prefix when using embedded fs
@@ -28,28 +32,99 @@ func (app *application) routes() http.Handler {
 
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authentication)
 
+	/* INFO: Only routes using this chain can be used with an API token, the
+	   Authorization header is ignored everywhere else */
+	tokenable := alice.New(app.sessionManager.LoadAndSave, app.tokenAuthentication, noSurf, app.authentication)
+
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
-	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/view/:id", tokenable.Append(app.requireScope(models.ScopeSnippetsRead)).ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/u/:username", dynamic.ThenFunc(app.userProfile))
 
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
 
+	router.Handler(http.MethodGet, "/user/account/email/confirm", dynamic.ThenFunc(app.emailChangeConfirm))
+
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
 
 	protected := dynamic.Append(app.requireAuthentication)
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/create", tokenable.Append(app.requireAuthentication, app.requireScope(models.ScopeSnippetsWrite)).ThenFunc(app.snippetCreatePost))
 	router.Handler(http.MethodGet, "/user/account", protected.ThenFunc(app.accountView))
 	router.Handler(http.MethodGet, "/user/changepassword", protected.ThenFunc(app.changePasswordView))
 	router.Handler(http.MethodPost, "/user/changepassword", protected.ThenFunc(app.changePasswordPost))
+	router.Handler(http.MethodGet, "/user/account/edit", protected.ThenFunc(app.accountEdit))
+	router.Handler(http.MethodPost, "/user/account/edit", protected.ThenFunc(app.accountEditPost))
+	router.Handler(http.MethodGet, "/user/account/delete", protected.ThenFunc(app.accountDelete))
+	router.Handler(http.MethodPost, "/user/account/delete", protected.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodGet, "/user/account/export", protected.ThenFunc(app.exportView))
+	router.Handler(http.MethodPost, "/user/account/export", protected.ThenFunc(app.exportPost))
+	router.Handler(http.MethodGet, "/user/account/export/:id", protected.ThenFunc(app.exportDownload))
+	router.Handler(http.MethodGet, "/user/account/tokens", protected.ThenFunc(app.tokensView))
+	router.Handler(http.MethodPost, "/user/account/tokens", protected.ThenFunc(app.tokensPost))
+	router.Handler(http.MethodPost, "/user/account/tokens/:id/revoke", protected.ThenFunc(app.tokenRevokePost))
+	router.Handler(http.MethodGet, "/user/account/webhooks", protected.ThenFunc(app.webhooksView))
+	router.Handler(http.MethodPost, "/user/account/webhooks", protected.ThenFunc(app.webhooksPost))
+	router.Handler(http.MethodGet, "/user/account/webhooks/:id", protected.ThenFunc(app.webhookView))
+	router.Handler(http.MethodPost, "/user/account/webhooks/:id/delete", protected.ThenFunc(app.webhookDeletePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodGet, "/org/create", protected.ThenFunc(app.orgCreate))
+	router.Handler(http.MethodPost, "/org/create", protected.ThenFunc(app.orgCreatePost))
+	router.Handler(http.MethodGet, "/org/view/:slug", protected.ThenFunc(app.orgView))
+	router.Handler(http.MethodPost, "/org/view/:slug/invite", protected.ThenFunc(app.orgInvitePost))
+	router.Handler(http.MethodGet, "/org/accept", protected.ThenFunc(app.orgAccept))
+
+	moderator := protected.Append(app.requireRole(models.RoleModerator))
+	router.Handler(http.MethodGet, "/admin", moderator.ThenFunc(app.adminHome))
+	router.Handler(http.MethodGet, "/admin/snippets", moderator.ThenFunc(app.adminSnippets))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/delete", moderator.ThenFunc(app.adminSnippetDeletePost))
+
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/:id/disable", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/reset-password", admin.ThenFunc(app.adminUserResetPasswordPost))
+
+	for _, route := range app.apiRoutes() {
+		router.Handler(route.method, route.path, route.handler)
+	}
+	/* INFO: For curl, no sessions and no CSRF either */
+	paste := alice.New(app.tokenAuthentication, app.requireScope(models.ScopeSnippetsWrite), app.rateLimit(app.pasteLimiter))
+	router.Handler(http.MethodPost, "/paste", paste.ThenFunc(app.paste))
+
+	router.HandlerFunc(http.MethodGet, "/api/openapi.json", app.apiSpec)
+	router.Handler(http.MethodGet, "/api/docs", dynamic.ThenFunc(app.apiDocs))
 
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	/* INFO: logRequest wraps recoverPanic so that panics show up in the
+	   access log with their 500 */
+	standard := alice.New(app.requestID, app.traceRequest, app.logRequest, app.measureRequest, app.recoverPanic, app.secureHeaders)
 
 	/* INFO: flow of exeuction:
 	   secureHeaders → servemux → application handler → servemux → secureHeaders */
-	// INFO: Without alice: return app.recoverPanic(app.logRequest(secureHeaders(mux)))
+	// INFO: Without alice: return app.requestID(app.traceRequest(app.logRequest(app.measureRequest(app.recoverPanic(app.secureHeaders(mux))))))
 	return standard.Then(router)
 }
+
+type apiRoute struct {
+	method  string
+	path    string
+	handler http.Handler
+}
+
+// Every route of the JSON API. Kept as a list rather than registered directly
+// so the tests can check that each of them is described in ui/api/openapi.json
+func (app *application) apiRoutes() []apiRoute {
+	/* INFO: No sessions and no CSRF, the API only knows about tokens */
+	api := alice.New(app.apiAuthentication)
+	apiRead := api.Append(app.apiRequireScope(models.ScopeSnippetsRead))
+	apiWrite := api.Append(app.apiRequireToken, app.apiRequireScope(models.ScopeSnippetsWrite))
+
+	return []apiRoute{
+		{http.MethodGet, "/api/v1/snippets", apiRead.ThenFunc(app.apiSnippetsList)},
+		{http.MethodPost, "/api/v1/snippets", apiWrite.ThenFunc(app.apiSnippetCreate)},
+		{http.MethodGet, "/api/v1/snippets/:id", apiRead.ThenFunc(app.apiSnippetView)},
+		{http.MethodPatch, "/api/v1/snippets/:id", apiWrite.ThenFunc(app.apiSnippetUpdate)},
+		{http.MethodDelete, "/api/v1/snippets/:id", apiWrite.ThenFunc(app.apiSnippetDelete)},
+	}
+}
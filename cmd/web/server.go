@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Answers every request with a permanent redirect to the same URL on the
+// HTTPS server, which listens on httpsPort
+func httpsRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		/* INFO: JoinHostPort also puts IPv6 addresses in brackets */
+		if httpsPort == "443" {
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		} else {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+/* A server for serve() to run, listen starts it, eg. with or without TLS */
+type listener struct {
+	name   string
+	server *http.Server
+	listen func() error
+}
+
+// Runs the listeners and the webhook worker until SIGINT or SIGTERM, then
+// shuts down gracefully. /readyz starts failing while requests are still
+// served for drainDelay, so load balancers stop sending new ones. Then the
+// listeners stop accepting connections, in-flight requests are finished and
+// the background work is drained, all within timeout. Returns early with the
+// error of a listener which failed to start.
+func (app *application) serve(listeners []listener, workerInterval, drainDelay, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.webhookWorker(workerCtx, workerInterval)
+	}()
+
+	listenErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			app.logger.Info("starting "+l.name, slog.String("addr", l.server.Addr))
+
+			err := l.listen()
+			/* INFO: Returned as soon as Shutdown is called, that is no failure */
+			if !errors.Is(err, http.ErrServerClosed) {
+				listenErr <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-listenErr:
+		app.logger.Error("listener failed, shutting down", slog.String("error", err.Error()))
+	case <-ctx.Done():
+		app.logger.Info("shutting down")
+	}
+
+	/* INFO: A second signal kills the process straight away, also while
+	   waiting for the load balancers */
+	stop()
+	app.shuttingDown.Store(true)
+
+	if drainDelay > 0 {
+		app.logger.Info("draining", slog.Duration("delay", drainDelay))
+		time.Sleep(drainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, l := range listeners {
+		err = errors.Join(err, l.server.Shutdown(shutdownCtx))
+	}
+
+	stopWorker()
+
+	drained := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-shutdownCtx.Done():
+		err = errors.Join(err, errors.New("background work did not finish in time"))
+	}
+
+	return err
+}
@@ -0,0 +1,151 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsPort string
+		host      string
+		target    string
+		wantCode  int
+		wantURL   string
+	}{
+		{
+			name:      "Default port",
+			httpsPort: "443",
+			host:      "snippetbox.example.com",
+			target:    "/snippet/view/1?x=y",
+			wantCode:  http.StatusMovedPermanently,
+			wantURL:   "https://snippetbox.example.com/snippet/view/1?x=y",
+		},
+		{
+			name:      "Other port",
+			httpsPort: "4000",
+			host:      "localhost:8080",
+			target:    "/",
+			wantCode:  http.StatusMovedPermanently,
+			wantURL:   "https://localhost:4000/",
+		},
+		{
+			name:      "IPv6",
+			httpsPort: "443",
+			host:      "[::1]:80",
+			target:    "/about",
+			wantCode:  http.StatusMovedPermanently,
+			wantURL:   "https://[::1]/about",
+		},
+		{
+			name:      "IPv6 without port",
+			httpsPort: "4000",
+			host:      "[::1]",
+			target:    "/about",
+			wantCode:  http.StatusMovedPermanently,
+			wantURL:   "https://[::1]:4000/about",
+		},
+		{
+			name:      "No host",
+			httpsPort: "443",
+			host:      "",
+			target:    "/",
+			wantCode:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			r.Host = tt.host
+
+			rr := httptest.NewRecorder()
+			httpsRedirect(tt.httpsPort).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			assert.Equal(t, rr.Header().Get("Location"), tt.wantURL)
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	app := newTestApplication(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/up", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("finished"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+
+	server := &http.Server{Handler: mux}
+	listeners := []listener{{name: "test server", server: server, listen: func() error { return server.Serve(ln) }}}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serve(listeners, time.Hour, 200*time.Millisecond, 5*time.Second)
+	}()
+
+	url := "http://" + ln.Addr().String()
+
+	/* INFO: Once the listener answers, serve() is catching the signals, so
+	   the one below can not kill the test */
+	for {
+		rs, err := http.Get(url + "/up")
+		if err == nil {
+			rs.Body.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	slow := make(chan string, 1)
+	go func() {
+		rs, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer rs.Body.Close()
+		body, _ := io.ReadAll(rs.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	err = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	assert.NilError(t, err)
+
+	/* Shutting down, but during the drain delay new requests are still
+	   answered and the request in flight is not cut off */
+	for !app.shuttingDown.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rs, err := http.Get(url + "/up")
+	assert.NilError(t, err)
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+	close(release)
+
+	assert.Equal(t, <-slow, "finished")
+	assert.NilError(t, <-served)
+
+	_, err = http.Get(url + "/up")
+	assert.Equal(t, err != nil, true)
+}
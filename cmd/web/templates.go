@@ -5,6 +5,7 @@ import (
 	"io/fs"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/justinas/nosurf"
@@ -21,6 +22,20 @@ type templateData struct {
 	IsAuthenticated bool
 	CSRFToken       string
 	User            *models.User
+	Author          *models.User
+	Export          *models.Export
+	Users           []*models.User
+	Search          string
+	Page            int
+	Organisation    *models.Organisation
+	Organisations   []*models.Organisation
+	Members         []*models.Member
+	APIToken        *models.APIToken
+	APITokens       []*models.APIToken
+	APIDocs         *apiDocs
+	Webhook         *models.Webhook
+	Webhooks        []*models.Webhook
+	Deliveries      []*models.WebhookDelivery
 }
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
@@ -41,8 +56,19 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+func add(a, b int) int {
+	return a + b
+}
+
+/* Whether the list contains value, for ticking checkboxes */
+func contains(list []string, value string) bool {
+	return slices.Contains(list, value)
+}
+
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"add":       add,
+	"contains":  contains,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
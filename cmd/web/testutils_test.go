@@ -4,18 +4,22 @@ import (
 	"bytes"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"github.com/mohafarman/snippetbox/internal/models/mocks"
+	"github.com/mohafarman/snippetbox/internal/passwords"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='(.+)'>`)
@@ -33,16 +37,50 @@ func newTestApplication(t *testing.T) *application {
 	sessionsManager.Cookie.Secure = true
 
 	return &application{
-		errorLog:       log.New(io.Discard, "", 0),
-		infoLog:        log.New(io.Discard, "", 0),
+		logger:         slog.New(slog.DiscardHandler),
+		metrics:        newMetrics(prometheus.NewRegistry()),
 		snippets:       &mocks.SnippetModel{},
 		users:          &mocks.UserModel{},
+		rememberTokens: &mocks.RememberTokenModel{},
+		exports:        &mocks.ExportModel{},
+		organisations:  &mocks.OrganisationModel{},
+		apiTokens:      &mocks.APITokenModel{},
+		webhooks:       &mocks.WebhookModel{},
+		webhookClient:  newWebhookClient(5*time.Second, true),
+		pasteMaxSize:   1024,
+		pasteLimiter:   newRateLimiter(1000),
 		templates:      templates,
 		form:           formDecoder,
 		sessionManager: sessionsManager,
+		mailer:         &testMailer{},
+		passwordPolicy: passwords.NewPolicy(
+			passwords.MinLength(8),
+			passwords.MinEntropy(40),
+			passwords.NotBreached(passwords.BreachedSet{"pa$$word123": true}),
+		),
+		rememberLifetime: 30 * 24 * time.Hour,
+		baseURL:          "https://snippetbox.example",
 	}
 }
 
+type testEmail struct {
+	recipient, subject, body string
+}
+
+// Records emails instead of sending them
+type testMailer struct {
+	mu   sync.Mutex
+	sent []testEmail
+}
+
+func (m *testMailer) Send(recipient, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, testEmail{recipient, subject, body})
+	return nil
+}
+
 // embeds httptest.Server
 type testServer struct {
 	*httptest.Server
@@ -97,7 +135,17 @@ func extractCSRFToken(t *testing.T, body string) string {
 }
 
 func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
-	rs, err := ts.Client().PostForm(ts.URL+urlPath, form)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// nosurf rejects secure requests which are not from the same origin, a
+	// browser would send the Origin header for us
+	req.Header.Set("Origin", ts.URL)
+
+	rs, err := ts.Client().Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,3 +159,46 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// Logs in through the real login form, so the test server's cookie jar holds
+// an authenticated session afterwards
+// Sends a request with the given headers and without any of the cookies or
+// headers a browser would add, as a script using an API token would
+func (ts *testServer) do(t *testing.T, method, urlPath string, header http.Header, body io.Reader) (int, http.Header, string) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(b)
+}
+
+func (ts *testServer) login(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+type apiTokenForm struct {
+	Name   string   `form:"name"`
+	Scopes []string `form:"scopes"`
+	/* Days until the token expires, 0 for never */
+	Expires             int `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) tokensView(w http.ResponseWriter, r *http.Request) {
+	app.renderTokens(w, r, http.StatusOK, apiTokenForm{
+		Scopes:  []string{models.ScopeSnippetsRead},
+		Expires: 30,
+	}, nil)
+}
+
+func (app *application) tokensPost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters.")
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Choose at least one scope")
+	for _, scope := range form.Scopes {
+		form.CheckField(models.ValidScope(scope), "scopes", "This field must equal snippets:read or snippets:write.")
+	}
+	form.CheckField(validator.PermittedValue(form.Expires, 0, 7, 30, 90, 365), "expires", "This field must equal 0, 7, 30, 90 or 365.")
+
+	if !form.Valid() {
+		app.renderTokens(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	var expires time.Time
+	if form.Expires > 0 {
+		expires = time.Now().AddDate(0, 0, form.Expires)
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	token, err := app.apiTokens.New(r.Context(), userID, form.Name, form.Scopes, expires)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	/* INFO: Rendered rather than redirected to, this is the only time the
+	   token is ever shown and it must not end up in the session store */
+	app.renderTokens(w, r, http.StatusOK, apiTokenForm{
+		Scopes:  []string{models.ScopeSnippetsRead},
+		Expires: 30,
+	}, token)
+}
+
+func (app *application) tokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.errorNotFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err := app.apiTokens.Revoke(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Token revoked.")
+
+	http.Redirect(w, r, "/user/account/tokens", http.StatusSeeOther)
+}
+
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, status int, form apiTokenForm, created *models.APIToken) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	tokens, err := app.apiTokens.ForUser(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.APIToken = created
+	data.APITokens = tokens
+
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, r, status, "tokens.tmpl.html", data)
+}
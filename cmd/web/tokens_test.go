@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestTokenAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	snippet := url.Values{}
+	snippet.Add("title", "From a script")
+	snippet.Add("content", "Hello")
+	snippet.Add("expires", "7")
+	snippet.Add("visibility", "public")
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "Private snippet with read token",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/3",
+			token:    "sbx_read",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Private snippet without token",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid token",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/1",
+			token:    "sbx_invalid",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Token of a user who has to choose a new password",
+			method:   http.MethodGet,
+			urlPath:  "/snippet/view/1",
+			token:    "sbx_carol",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Create with write token skips CSRF",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/create",
+			token:    "sbx_write",
+			body:     snippet.Encode(),
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Create with read token",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/create",
+			token:    "sbx_read",
+			body:     snippet.Encode(),
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Create without token or CSRF",
+			method:   http.MethodPost,
+			urlPath:  "/snippet/create",
+			body:     snippet.Encode(),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Route which does not accept tokens",
+			method:   http.MethodGet,
+			urlPath:  "/user/account",
+			token:    "sbx_write",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			code, _, _ := ts.do(t, tt.method, tt.urlPath, header, strings.NewReader(tt.body))
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestTokensCreate(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	_, _, body := ts.get(t, "/user/account/tokens")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		token    string
+		scopes   []string
+		expires  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid",
+			token:    "deploy",
+			scopes:   []string{"snippets:read", "snippets:write"},
+			expires:  "30",
+			wantCode: http.StatusOK,
+			wantBody: "sbx_new",
+		},
+		{
+			name:     "No scopes",
+			token:    "deploy",
+			expires:  "30",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Choose at least one scope",
+		},
+		{
+			name:     "Unknown scope",
+			token:    "deploy",
+			scopes:   []string{"users:write"},
+			expires:  "0",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal snippets:read or snippets:write.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.token)
+			for _, scope := range tt.scopes {
+				form.Add("scopes", scope)
+			}
+			form.Add("expires", tt.expires)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/account/tokens", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Looked up on every use rather than once, so that the tracer provider can be
+// swapped out, eg. by the tests
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/mohafarman/snippetbox/cmd/web")
+}
+
+var traceExporters = []string{"none", "stdout", "file", "otlp"}
+
+func validTraceExporter(exporter string) bool {
+	for _, e := range traceExporters {
+		if e == exporter {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sets up the global tracer provider to send spans to the configured
+// exporter. shutdown flushes whatever has not been exported yet. With the
+// "none" exporter nothing is recorded, but W3C traceparent headers are still
+// taken from incoming requests. cfg has to have been validated.
+func setupTracing(cfg tracingConfig, stdout io.Writer) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case "file":
+		file, err = os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("snippetbox"))),
+		/* INFO: A caller which has already decided whether to sample the
+		   trace is followed, the ratio only applies to new traces */
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	shutdown = func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}
+
+	return shutdown, nil
+}
+
+// Makes every request a span, continuing the trace of an incoming
+// traceparent header. The span is named after the route pattern once the
+// router has matched one. Has to come after requestID.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(app.clientIP(r)),
+				attribute.String("request_id", requestIDFromContext(r)),
+			))
+		defer span.End()
+
+		rw := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		status := rw.statusCode()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+
+		if route := routeFromContext(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+
+		/* INFO: Client errors are the clients problem, only 5xx mark the span */
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+)
+
+/* Makes the global tracer provider record every span until the test is over */
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	return recorder
+}
+
+func TestTraceRequest(t *testing.T) {
+	recorder := recordSpans(t)
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	header := make(http.Header)
+	header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	code, _, _ := ts.do(t, http.MethodGet, "/snippet/view/1", header, nil)
+	assert.Equal(t, code, http.StatusOK)
+
+	spans := recorder.Ended()
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		byName[s.Name()] = s
+	}
+
+	server, ok := byName["GET /snippet/view/:id"]
+	assert.Equal(t, ok, true)
+	assert.Equal(t, server.SpanContext().TraceID().String(), traceID)
+	assert.Equal(t, server.Parent().SpanID().String(), "00f067aa0ba902b7")
+
+	var status int64
+	for _, attr := range server.Attributes() {
+		if attr.Key == semconv.HTTPResponseStatusCodeKey {
+			status = attr.Value.AsInt64()
+		}
+	}
+	assert.Equal(t, status, int64(http.StatusOK))
+
+	render, ok := byName["render view.tmpl.html"]
+	assert.Equal(t, ok, true)
+	assert.Equal(t, render.Parent().SpanID(), server.SpanContext().SpanID())
+}
+
+func TestSetupTracing(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := setupTracing(tracingConfig{Exporter: "file", File: path, SampleRatio: 1}, nil)
+	assert.NilError(t, err)
+
+	_, span := tracer().Start(t.Context(), "offline")
+	span.End()
+
+	err = shutdown(t.Context())
+	assert.NilError(t, err)
+
+	b, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.StringContains(t, string(b), `"Name":"offline"`)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+/* Deliveries shown in the log of a webhook */
+const webhookLogSize = 50
+
+type webhookForm struct {
+	URL    string   `form:"url"`
+	Events []string `form:"events"`
+	/* Only admins may choose this */
+	AllSnippets         bool `form:"all_snippets"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) webhooksView(w http.ResponseWriter, r *http.Request) {
+	app.renderWebhooks(w, r, http.StatusOK, webhookForm{
+		Events: []string{models.EventSnippetCreated},
+	})
+}
+
+func (app *application) webhooksPost(w http.ResponseWriter, r *http.Request) {
+	var form webhookForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.errorClient(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.URL), "url", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.URL, 2048), "url", "This field cannot be more than 2048 characters.")
+	form.CheckField(validWebhookURL(form.URL), "url", "This field must be an http or https url.")
+	form.CheckField(len(form.Events) > 0, "events", "Choose at least one event")
+	for _, event := range form.Events {
+		form.CheckField(models.ValidEvent(event), "events", "This field must equal snippet.created, snippet.updated, snippet.deleted or snippet.expired.")
+	}
+	form.CheckField(!form.AllSnippets || user.HasRole(models.RoleAdmin), "all_snippets", "Only admins can receive events for every snippet")
+
+	if !form.Valid() {
+		app.renderWebhooks(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	webhook, err := app.webhooks.Insert(r.Context(), userID, form.URL, form.Events, form.AllSnippets)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook added.")
+
+	http.Redirect(w, r, fmt.Sprintf("/user/account/webhooks/%d", webhook.ID), http.StatusSeeOther)
+}
+
+/* The webhook, its secret and its delivery log */
+func (app *application) webhookView(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromParams(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := app.webhooks.Deliveries(r.Context(), webhook.ID, webhookLogSize)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhook = webhook
+	data.Deliveries = deliveries
+
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, r, http.StatusOK, "webhook.tmpl.html", data)
+}
+
+func (app *application) webhookDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.errorNotFound(w)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err := app.webhooks.Delete(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook deleted.")
+
+	http.Redirect(w, r, "/user/account/webhooks", http.StatusSeeOther)
+}
+
+func (app *application) renderWebhooks(w http.ResponseWriter, r *http.Request, status int, form webhookForm) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	webhooks, err := app.webhooks.ForUser(r.Context(), userID)
+	if err != nil {
+		app.errorServer(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.User = user
+	data.Webhooks = webhooks
+
+	app.render(w, r, status, "webhooks.tmpl.html", data)
+}
+
+/* Responds with a 404 unless the webhook exists and belongs to the current user */
+func (app *application) webhookFromParams(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, ok := idFromParams(r)
+	if !ok {
+		app.errorNotFound(w)
+		return nil, false
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	webhook, err := app.webhooks.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorNotFound(w)
+		} else {
+			app.errorServer(w, r, err)
+		}
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+func validWebhookURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/models"
+	"github.com/mohafarman/snippetbox/internal/models/mocks"
+)
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// A local webhook endpoint which records every delivery and answers with
+// status
+type testReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	received []receivedWebhook
+}
+
+func newTestReceiver(t *testing.T, status int) *testReceiver {
+	tr := &testReceiver{status: status}
+
+	tr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+
+		tr.received = append(tr.received, receivedWebhook{r.Header, body})
+		w.WriteHeader(tr.status)
+		io.WriteString(w, http.StatusText(tr.status))
+	}))
+	t.Cleanup(tr.Close)
+
+	return tr
+}
+
+func (tr *testReceiver) deliveries() []receivedWebhook {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return tr.received
+}
+
+func TestWebhooksCreate(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		email        string
+		url          string
+		events       []string
+		allSnippets  bool
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid",
+			email:        "bob@example.com",
+			url:          "https://chat.example.com/hooks/snippets",
+			events:       []string{models.EventSnippetCreated, models.EventSnippetExpired},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/account/webhooks/2",
+		},
+		{
+			name:     "Blank url",
+			email:    "bob@example.com",
+			events:   []string{models.EventSnippetCreated},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Not an http url",
+			email:    "bob@example.com",
+			url:      "ftp://example.com/hook",
+			events:   []string{models.EventSnippetCreated},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be an http or https url.",
+		},
+		{
+			name:     "No events",
+			email:    "bob@example.com",
+			url:      "https://example.com/hook",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Choose at least one event",
+		},
+		{
+			name:     "Unknown event",
+			email:    "bob@example.com",
+			url:      "https://example.com/hook",
+			events:   []string{"user.created"},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal snippet.created",
+		},
+		{
+			name:        "Every snippet as a user",
+			email:       "bob@example.com",
+			url:         "https://example.com/hook",
+			events:      []string{models.EventSnippetCreated},
+			allSnippets: true,
+			wantCode:    http.StatusUnprocessableEntity,
+			wantBody:    "Only admins can receive events for every snippet",
+		},
+		{
+			name:         "Every snippet as an admin",
+			email:        "alice@example.com",
+			url:          "https://example.com/hook",
+			events:       []string{models.EventSnippetCreated},
+			allSnippets:  true,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/account/webhooks/2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts.login(t, tt.email, "password")
+
+			_, _, body := ts.get(t, "/user/account/webhooks")
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("url", tt.url)
+			for _, event := range tt.events {
+				form.Add("events", event)
+			}
+			if tt.allSnippets {
+				form.Add("all_snippets", "true")
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/user/account/webhooks", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestWebhookView(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "List",
+			urlPath:  "/user/account/webhooks",
+			wantCode: http.StatusOK,
+			wantBody: "https://example.com/hook",
+		},
+		{
+			name:     "Own webhook shows its secret",
+			urlPath:  "/user/account/webhooks/1",
+			wantCode: http.StatusOK,
+			wantBody: "whsec_test",
+		},
+		{
+			name:     "Somebody elses webhook",
+			urlPath:  "/user/account/webhooks/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid id",
+			urlPath:  "/user/account/webhooks/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestWebhookDelete(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "password")
+
+	_, _, body := ts.get(t, "/user/account/webhooks/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/user/account/webhooks/1/delete", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/account/webhooks")
+
+	code, _, _ = ts.postForm(t, "/user/account/webhooks/2/delete", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusNoContent)
+
+	app := newTestApplication(t)
+	app.webhooks = &mocks.WebhookModel{URL: receiver.URL}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer sbx_write")
+	header.Set("Content-Type", "application/json")
+
+	code, _, _ := ts.do(t, http.MethodPost, "/api/v1/snippets", header,
+		strings.NewReader(`{"title": "Deploy log", "content": "ok", "expires": 7, "visibility": "public"}`))
+	assert.Equal(t, code, http.StatusCreated)
+
+	/* Nothing is sent until the worker gets to the outbox */
+	assert.Equal(t, len(receiver.deliveries()), 0)
+
+	app.deliverWebhooks(t.Context())
+
+	received := receiver.deliveries()
+	assert.Equal(t, len(received), 1)
+
+	got := received[0]
+	assert.Equal(t, got.header.Get("Content-Type"), "application/json")
+	assert.Equal(t, got.header.Get("X-Snippetbox-Event"), models.EventSnippetCreated)
+	assert.Equal(t, got.header.Get("X-Snippetbox-Delivery"), "1")
+
+	timestamp, err := strconv.ParseInt(got.header.Get("X-Snippetbox-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.header.Get("X-Snippetbox-Signature"), signWebhook("whsec_test", timestamp, got.body))
+
+	var payload webhookPayload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, payload.Event, models.EventSnippetCreated)
+	assert.Equal(t, payload.Snippet.ID, 2)
+	assert.Equal(t, payload.Snippet.Title, "Deploy log")
+	assert.Equal(t, payload.Snippet.URL, "https://snippetbox.example/snippet/view/2")
+
+	/* Delivered deliveries are not sent again */
+	app.deliverWebhooks(t.Context())
+	assert.Equal(t, len(receiver.deliveries()), 1)
+
+	ts.login(t, "bob@example.com", "password")
+
+	_, _, body := ts.get(t, "/user/account/webhooks/1")
+	assert.StringContains(t, body, "Delivered")
+}
+
+func TestWebhookRetry(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusInternalServerError)
+
+	app := newTestApplication(t)
+	webhooks := &mocks.WebhookModel{URL: receiver.URL}
+	app.webhooks = webhooks
+
+	app.queueWebhooks(t.Context(), models.EventSnippetDeleted, &models.Snippet{ID: 1, UserID: 1})
+
+	app.deliverWebhooks(t.Context())
+	assert.Equal(t, len(receiver.deliveries()), 1)
+
+	deliveries, err := webhooks.Deliveries(t.Context(), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := deliveries[0]
+	assert.Equal(t, d.Status, models.DeliveryPending)
+	assert.Equal(t, d.Attempts, 1)
+	assert.Equal(t, d.ResponseStatus, http.StatusInternalServerError)
+	/* The response body is not kept */
+	assert.Equal(t, d.LastError, "unexpected response 500 Internal Server Error")
+	assert.Equal(t, d.NextAttempt.After(time.Now()), true)
+
+	/* Not due again until the backoff has passed */
+	app.deliverWebhooks(t.Context())
+	assert.Equal(t, len(receiver.deliveries()), 1)
+}
+
+func TestWebhookExpired(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusOK)
+
+	app := newTestApplication(t)
+	app.webhooks = &mocks.WebhookModel{URL: receiver.URL}
+
+	app.queueExpiredSnippets(t.Context())
+	app.deliverWebhooks(t.Context())
+
+	received := receiver.deliveries()
+	assert.Equal(t, len(received), 1)
+
+	var payload webhookPayload
+	if err := json.Unmarshal(received[0].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, payload.Event, models.EventSnippetExpired)
+	assert.Equal(t, payload.Snippet.ID, 5)
+	/* There is no request, the link still comes from the base URL */
+	assert.Equal(t, payload.Snippet.URL, "https://snippetbox.example/snippet/view/5")
+}
+
+func TestWebhookNotSubscribed(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusOK)
+
+	app := newTestApplication(t)
+	app.webhooks = &mocks.WebhookModel{URL: receiver.URL}
+
+	/* Alice has no webhooks */
+	app.queueWebhooks(t.Context(), models.EventSnippetCreated, &models.Snippet{ID: 2, UserID: 2})
+	app.deliverWebhooks(t.Context())
+
+	assert.Equal(t, len(receiver.deliveries()), 0)
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	receiver := newTestReceiver(t, http.StatusOK)
+
+	app := newTestApplication(t)
+	webhooks := &mocks.WebhookModel{URL: receiver.URL}
+	app.webhooks = webhooks
+	app.webhookClient = newWebhookClient(5*time.Second, false)
+
+	app.queueWebhooks(t.Context(), models.EventSnippetDeleted, &models.Snippet{ID: 1, UserID: 1})
+	app.deliverWebhooks(t.Context())
+
+	/* The receiver listens on 127.0.0.1 */
+	assert.Equal(t, len(receiver.deliveries()), 0)
+
+	deliveries, err := webhooks.Deliveries(t.Context(), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, deliveries[0].Status, models.DeliveryPending)
+	assert.StringContains(t, deliveries[0].LastError, "address is not allowed")
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "93.184.215.14", want: true},
+		{addr: "2606:2800:21f:cb07:6820:80da:af6b:8b2c", want: true},
+		{addr: "127.0.0.1", want: false},
+		{addr: "::1", want: false},
+		{addr: "10.1.2.3", want: false},
+		{addr: "172.16.0.1", want: false},
+		{addr: "192.168.1.1", want: false},
+		{addr: "fd00::1", want: false},
+		{addr: "169.254.169.254", want: false},
+		{addr: "fe80::1", want: false},
+		{addr: "0.0.0.0", want: false},
+		{addr: "::", want: false},
+		{addr: "::ffff:127.0.0.1", want: false},
+		{addr: "224.0.0.1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, publicAddr(netip.MustParseAddr(tt.addr)), tt.want)
+		})
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	/* Computed independently with: printf '1700000000.{}' | openssl dgst -sha256 -hmac whsec_test */
+	want := "sha256=35495024f4ef3f94e5a93e22221544c4b75e9a42300cd965ab81cb85cd994e91"
+
+	assert.Equal(t, signWebhook("whsec_test", 1700000000, []byte("{}")), want)
+
+	/* Any change to the secret, timestamp or body changes the signature */
+	assert.Equal(t, signWebhook("whsec_other", 1700000000, []byte("{}")) == want, false)
+	assert.Equal(t, signWebhook("whsec_test", 1700000001, []byte("{}")) == want, false)
+	assert.Equal(t, signWebhook("whsec_test", 1700000000, []byte("{ }")) == want, false)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 5, want: 8 * time.Minute},
+		{attempts: 11, want: 512 * time.Minute},
+		{attempts: 12, want: 12 * time.Hour},
+		{attempts: 100, want: 12 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempts), func(t *testing.T) {
+			assert.Equal(t, webhookBackoff(tt.attempts), tt.want)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+// Package database hides the differences between the databases snippetbox
+// runs on, SQLite and PostgreSQL. Queries are written once with ? placeholders
+// and standard SQL, DB rebinds the placeholders for the dialect it is connected
+// to. Anything else which differs, like how a unique constraint violation is
+// reported, has a helper here.
+//
+// SQLite uses github.com/mattn/go-sqlite3 by default, which needs CGO. Build
+// with the sqlite_purego tag to use the pure Go modernc.org/sqlite instead:
+//
+//	CGO_ENABLED=0 go build -tags sqlite_purego ./cmd/web/
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Looked up on every use rather than once, so that the tracer provider can be
+// swapped out, eg. by the tests
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/mohafarman/snippetbox/internal/database")
+}
+
+/* The database snippetbox talks to, as named in the config */
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+/* Every supported dialect, in the order they are shown */
+var Dialects = []Dialect{SQLite, Postgres}
+
+func ValidDialect(d Dialect) bool {
+	return d == SQLite || d == Postgres
+}
+
+// Rewrites the ? placeholders in query to the dialects own, $1, $2, ... for
+// PostgreSQL. Question marks in quoted strings and identifiers are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	var quote rune
+
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// A connection pool which knows its dialect. Exec, Query, QueryRow, Begin and
+// their *Context variants rebind their query, every other method is the one
+// of *sql.DB.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+	/* Longest a model method may spend on the database, no limit when zero */
+	QueryTimeout time.Duration
+}
+
+func New(db *sql.DB, dialect Dialect) *DB {
+	return &DB{DB: db, Dialect: dialect}
+}
+
+/* Opens the database and checks that it can be reached */
+func Open(dialect Dialect, dsn string) (*DB, error) {
+	if !ValidDialect(dialect) {
+		return nil, fmt.Errorf("database: unknown driver %q", dialect)
+	}
+
+	driver := string(dialect)
+	if dialect == SQLite {
+		driver = sqliteDriver
+		dsn = sqliteDSN(dsn)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return New(db, dialect), nil
+}
+
+// Derives the context a model method runs its queries with, bounded by
+// QueryTimeout. cancel must be called once the method is done with its
+// result rows.
+func (db *DB) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, db.QueryTimeout)
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, dialect: db.Dialect}, nil
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = db.Dialect.Rebind(query)
+
+	ctx, span := db.Dialect.startSpan(ctx, query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return result, err
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = db.Dialect.Rebind(query)
+
+	ctx, span := db.Dialect.startSpan(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return rows, err
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query = db.Dialect.Rebind(query)
+
+	ctx, span := db.Dialect.startSpan(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+
+	return row
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, dialect: db.Dialect}, nil
+}
+
+/* A transaction which rebinds its queries like DB */
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = tx.dialect.Rebind(query)
+
+	ctx, span := tx.dialect.startSpan(ctx, query)
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return result, err
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = tx.dialect.Rebind(query)
+
+	ctx, span := tx.dialect.startSpan(ctx, query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return rows, err
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query = tx.dialect.Rebind(query)
+
+	ctx, span := tx.dialect.startSpan(ctx, query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+
+	return row
+}
+
+// Starts the span of a single query, a child of whatever span is in ctx. Only
+// the statement is recorded, never its arguments, which hold passwords and
+// tokens. For Query the span ends once the query has run, reading the rows is
+// not part of it.
+func (d Dialect) startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	system := semconv.DBSystemNameSQLite
+	if d == Postgres {
+		system = semconv.DBSystemNamePostgreSQL
+	}
+
+	/* INFO: Named after the operation, eg. "SELECT", the statement would
+	   make for far too many different span names */
+	name := system.Value.AsString()
+	if fields := strings.Fields(query); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+
+	return tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(system, semconv.DBQueryText(query)))
+}
+
+/* No rows is an answer rather than a failure, so it is not recorded as one */
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
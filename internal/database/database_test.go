@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{
+			name:    "SQLite",
+			dialect: SQLite,
+			query:   "SELECT id FROM users WHERE email = ? AND role = ?",
+			want:    "SELECT id FROM users WHERE email = ? AND role = ?",
+		},
+		{
+			name:    "Postgres",
+			dialect: Postgres,
+			query:   "SELECT id FROM users WHERE email = ? AND role = ?",
+			want:    "SELECT id FROM users WHERE email = $1 AND role = $2",
+		},
+		{
+			name:    "Quoted",
+			dialect: Postgres,
+			query:   `SELECT '?', "a?" FROM t WHERE x = ? AND y = 'it''s ?'`,
+			want:    `SELECT '?', "a?" FROM t WHERE x = $1 AND y = 'it''s ?'`,
+		},
+		{
+			name:    "No placeholders",
+			dialect: Postgres,
+			query:   "SELECT 1",
+			want:    "SELECT 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.dialect.Rebind(tt.query), tt.want)
+		})
+	}
+}
+
+func TestUniqueViolation(t *testing.T) {
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "database.db"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE users (email TEXT NOT NULL UNIQUE)")
+	assert.NilError(t, err)
+
+	_, err = db.Exec("INSERT INTO users (email) VALUES (?)", "alice@example.com")
+	assert.NilError(t, err)
+
+	_, err = db.Exec("INSERT INTO users (email) VALUES (?)", "alice@example.com")
+	constraint, ok := UniqueViolation(err)
+	assert.Equal(t, ok, true)
+	assert.StringContains(t, constraint, "users.email")
+
+	/* Other constraints are not unique violations */
+	_, err = db.Exec("INSERT INTO users (email) VALUES (NULL)")
+	_, ok = UniqueViolation(err)
+	assert.Equal(t, ok, false)
+
+	pqErr := &pq.Error{Code: "23505", Constraint: "users_email_key"}
+	constraint, ok = UniqueViolation(pqErr)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, constraint, "users_email_key")
+
+	_, ok = UniqueViolation(&pq.Error{Code: "23503"})
+	assert.Equal(t, ok, false)
+
+	_, ok = UniqueViolation(errors.New("UNIQUE constraint failed"))
+	assert.Equal(t, ok, false)
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open("mysql", "snippetbox")
+	assert.StringContains(t, err.Error(), `unknown driver "mysql"`)
+}
+
+func TestQuerySpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "database.db"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.ExecContext(t.Context(), "CREATE TABLE users (email TEXT NOT NULL UNIQUE)")
+	assert.NilError(t, err)
+
+	_, err = db.ExecContext(t.Context(), "INSERT INTO users (email) VALUES (?)", "alice@example.com")
+	assert.NilError(t, err)
+
+	var email string
+	err = db.QueryRowContext(t.Context(), "SELECT email FROM users WHERE email = ?", "bob@example.com").Scan(&email)
+	assert.Equal(t, errors.Is(err, sql.ErrNoRows), true)
+
+	spans := recorder.Ended()
+	assert.Equal(t, len(spans), 3)
+
+	insert := spans[1]
+	assert.Equal(t, insert.Name(), "INSERT")
+
+	attrs := attribute.NewSet(insert.Attributes()...)
+	statement, _ := attrs.Value(semconv.DBQueryTextKey)
+	assert.Equal(t, statement.AsString(), "INSERT INTO users (email) VALUES (?)")
+
+	/* The arguments are never recorded */
+	for _, attr := range insert.Attributes() {
+		assert.Equal(t, attr.Value.Emit() == "alice@example.com", false)
+	}
+
+	/* No rows is not an error */
+	assert.Equal(t, spans[2].Status().Code.String(), "Unset")
+}
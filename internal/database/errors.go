@@ -0,0 +1,28 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/lib/pq/pqerror"
+)
+
+// Reports whether err is a violated UNIQUE or PRIMARY KEY constraint, and if
+// so something which names it: the "table.column" list for SQLite, the
+// constraint name, eg. "users_email_key", for PostgreSQL. Both contain the
+// column name for a constraint on a single column.
+//
+// Every driver has its own error type, so callers should use this rather
+// than errors.As.
+func UniqueViolation(err error) (string, bool) {
+	if constraint, ok := sqliteUniqueViolation(err); ok {
+		return constraint, true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqerror.UniqueViolation {
+		return pqErr.Constraint, true
+	}
+
+	return "", false
+}
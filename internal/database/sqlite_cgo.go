@@ -0,0 +1,30 @@
+//go:build !sqlite_purego
+
+package database
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3" /* INFO: Uses CGO */
+)
+
+const sqliteDriver = "sqlite3"
+
+/* mattn/go-sqlite3 takes the DSN as it is */
+func sqliteDSN(dsn string) string {
+	return dsn
+}
+
+func sqliteUniqueViolation(err error) (string, bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return "", false
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return sqliteErr.Error(), true
+	}
+
+	return "", false
+}
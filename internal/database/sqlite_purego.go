@@ -0,0 +1,56 @@
+//go:build sqlite_purego
+
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const sqliteDriver = "sqlite"
+
+// INFO: modernc.org/sqlite writes times with time.Time.String() unless told
+// otherwise, _time_format=sqlite makes it write them like mattn/go-sqlite3
+// does. Times are compared as text, so a database must only ever have one
+// format, whichever driver wrote it. It also has no busy timeout, a write
+// which meets another one fails straight away with SQLITE_BUSY, so it is
+// given the 5 seconds mattn/go-sqlite3 waits by default.
+func sqliteDSN(dsn string) string {
+	params := []string{}
+
+	if !strings.Contains(dsn, "_time_format=") {
+		params = append(params, "_time_format=sqlite")
+	}
+
+	if !strings.Contains(dsn, "busy_timeout") {
+		params = append(params, "_pragma=busy_timeout(5000)")
+	}
+
+	if len(params) == 0 {
+		return dsn
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	return dsn + separator + strings.Join(params, "&")
+}
+
+func sqliteUniqueViolation(err error) (string, bool) {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return "", false
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return sqliteErr.Error(), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,28 @@
+//go:build sqlite_purego
+
+package database
+
+import (
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"snippetbox", "snippetbox?_time_format=sqlite&_pragma=busy_timeout(5000)"},
+		{"snippetbox?parseTime=true", "snippetbox?parseTime=true&_time_format=sqlite&_pragma=busy_timeout(5000)"},
+		{"file:snippetbox?_time_format=sqlite", "file:snippetbox?_time_format=sqlite&_pragma=busy_timeout(5000)"},
+		{"file:snippetbox?_pragma=busy_timeout(100)", "file:snippetbox?_pragma=busy_timeout(100)&_time_format=sqlite"},
+		{"file:snippetbox?_time_format=sqlite&_pragma=busy_timeout(100)", "file:snippetbox?_time_format=sqlite&_pragma=busy_timeout(100)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			assert.Equal(t, sqliteDSN(tt.dsn), tt.want)
+		})
+	}
+}
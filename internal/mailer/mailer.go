@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type Sender interface {
+	Send(recipient, subject, body string) error
+}
+
+/* Sends plain text emails through an SMTP server */
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(recipient, subject, body string) error {
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + recipient,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(addr, auth, m.From, []string{recipient}, []byte(msg))
+}
+
+// INFO: Used when no SMTP server is configured, eg. in development. The
+// emails are written to the log instead of being sent.
+type Log struct {
+	Logger *log.Logger
+}
+
+func (m *Log) Send(recipient, subject, body string) error {
+	m.Logger.Printf("email to %s: %s\n%s", recipient, subject, body)
+	return nil
+}
@@ -0,0 +1,295 @@
+// Package migrations builds and changes the database schema. Migrations are
+// pairs of sql files embedded from sql/<dialect>/, named
+//
+//	<version>_<name>.up.sql
+//	<version>_<name>.down.sql
+//
+// where version is a number which orders them. Every dialect has its own
+// files, with the same versions, since column types and the like differ. Each one is applied or
+// reverted in its own transaction, together with the row which records it in
+// the schema_migrations table, so a failed migration leaves nothing behind.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+//go:embed sql/*/*.sql
+var files embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Reads every embedded migration for the dialect, ordered by version. Every
+// version needs both an up and a down file.
+func All(dialect database.Dialect) ([]Migration, error) {
+	return load(files, path.Join("sql", string(dialect)))
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, name := range names {
+		base := path.Base(name)
+
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migrations: %s is not named <version>_<name>.up.sql or .down.sql", base)
+		}
+
+		prefix, label, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migrations: %s does not start with a version number", base)
+		}
+
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+
+		if m.Name != label {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, m.Name, label)
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// The version the database is at, 0 for an empty database. It only reads, so
+// it is safe to call as often as /readyz is probed.
+func Current(db *database.DB) (int, error) {
+	exists, err := versionTableExists(db)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+/* The migrations which have not been applied yet, oldest first */
+func Pending(db *database.DB) ([]Migration, error) {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := Current(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+/* Applies every migration which has not been yet, returning the ones it applied */
+func Up(db *database.DB) ([]Migration, error) {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+
+	return migrate(db, migrations, migrations[len(migrations)-1].Version)
+}
+
+/* Reverts the most recently applied migration, if there is one */
+func Down(db *database.DB) ([]Migration, error) {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := Current(db)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := 0
+	for _, m := range migrations {
+		if m.Version < current {
+			previous = m.Version
+		}
+	}
+
+	return migrate(db, migrations, previous)
+}
+
+// Applies or reverts migrations until the database is at version, returning
+// the ones it applied or reverted in the order it did so. Version 0 reverts
+// everything.
+func To(db *database.DB, version int) ([]Migration, error) {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate(db, migrations, version)
+}
+
+func migrate(db *database.DB, migrations []Migration, target int) ([]Migration, error) {
+	exists := slices.ContainsFunc(migrations, func(m Migration) bool {
+		return m.Version == target
+	})
+	if target != 0 && !exists {
+		return nil, fmt.Errorf("migrations: there is no version %d", target)
+	}
+
+	err := createVersionTable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := Current(db)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version <= current || m.Version > target {
+				continue
+			}
+
+			err = apply(db, m.Up, "INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().UTC())
+			if err != nil {
+				return done, fmt.Errorf("migrations: applying %s: %w", m, err)
+			}
+			done = append(done, m)
+		}
+
+		return done, nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+
+		err = apply(db, m.Down, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+		if err != nil {
+			return done, fmt.Errorf("migrations: reverting %s: %w", m, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+/* Runs the migration and records it in one transaction */
+func apply(db *database.DB, script, record string, args ...any) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	/* INFO: Rollback is a no-op once the transaction has been committed */
+	defer tx.Rollback()
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createVersionTable(db *database.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied TIMESTAMP NOT NULL
+	)`
+
+	_, err := db.Exec(stmt)
+	return err
+}
+
+func versionTableExists(db *database.DB) (bool, error) {
+	var stmt string
+	if db.Dialect == database.Postgres {
+		stmt = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'"
+	} else {
+		stmt = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	}
+
+	var n int
+
+	err := db.QueryRow(stmt).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
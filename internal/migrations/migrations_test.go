@@ -0,0 +1,316 @@
+package migrations
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+/* An empty database of its own, in a temporary directory */
+func newTestDB(t *testing.T) *database.DB {
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func tables(t *testing.T, db *database.DB) []string {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+var testFiles = fstest.MapFS{
+	"sql/0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+	"sql/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+	"sql/0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+	"sql/0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	/* Fails half way through, after creating c */
+	"sql/0010_broken.up.sql":   {Data: []byte("CREATE TABLE c (id INTEGER); CREATE TABLE a (id INTEGER);")},
+	"sql/0010_broken.down.sql": {Data: []byte("DROP TABLE c;")},
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := load(testFiles, "sql")
+	assert.NilError(t, err)
+	assert.Equal(t, len(migrations), 3)
+	assert.Equal(t, migrations[1].String(), "0002_create_b")
+
+	db := newTestDB(t)
+
+	done, err := migrate(db, migrations, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), 2)
+	assert.Equal(t, strings.Join(tables(t, db), " "), "a b")
+
+	/* Already at version 2 */
+	done, err = migrate(db, migrations, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), 0)
+
+	/* The failed migration is rolled back as a whole and not recorded */
+	_, err = migrate(db, migrations, 10)
+	assert.StringContains(t, err.Error(), "applying 0010_broken")
+	assert.Equal(t, strings.Join(tables(t, db), " "), "a b")
+
+	version, err := Current(db)
+	assert.NilError(t, err)
+	assert.Equal(t, version, 2)
+
+	done, err = migrate(db, migrations, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), 1)
+	assert.Equal(t, done[0].Version, 2)
+	assert.Equal(t, strings.Join(tables(t, db), " "), "a")
+
+	_, err = migrate(db, migrations, 3)
+	assert.StringContains(t, err.Error(), "there is no version 3")
+
+	_, err = migrate(db, migrations, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tables(t, db)), 0)
+}
+
+func TestPendingReadOnly(t *testing.T) {
+	db := newTestDB(t)
+
+	pending, err := Pending(db)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pending) > 0, true)
+
+	/* Checking must not create the version table */
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&n)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+
+	_, err = Up(db)
+	assert.NilError(t, err)
+
+	pending, err = Pending(db)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pending), 0)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "Missing down",
+			files: fstest.MapFS{
+				"sql/0001_a.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "0001_a needs both an up and a down file",
+		},
+		{
+			name: "No version",
+			files: fstest.MapFS{
+				"sql/create_a.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "create_a.up.sql does not start with a version number",
+		},
+		{
+			name: "No direction",
+			files: fstest.MapFS{
+				"sql/0001_a.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "0001_a.sql is not named",
+		},
+		{
+			name: "Version used twice",
+			files: fstest.MapFS{
+				"sql/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+				"sql/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			wantErr: "version 1 is used by both",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.files, "sql")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			assert.StringContains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+/* The embedded migrations, all the way up and back down again */
+func TestEmbedded(t *testing.T) {
+	db := newTestDB(t)
+
+	all, err := All(database.SQLite)
+	assert.NilError(t, err)
+
+	pending, err := Pending(db)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pending), len(all))
+
+	done, err := Up(db)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), len(all))
+
+	pending, err = Pending(db)
+	assert.NilError(t, err)
+	assert.Equal(t, len(pending), 0)
+
+	version, err := Current(db)
+	assert.NilError(t, err)
+	assert.Equal(t, version, all[len(all)-1].Version)
+
+	for range all {
+		_, err = Down(db)
+		assert.NilError(t, err)
+	}
+
+	assert.Equal(t, len(tables(t, db)), 0)
+}
+
+// The schema as it was created by hand before there were migrations,
+// together with some data which has to survive the upgrade
+const baselineSchema = `
+CREATE TABLE snippets (
+    id INTEGER NOT NULL PRIMARY KEY,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_snippets_created ON snippets(created);
+
+CREATE TABLE users (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    hashed_password CHAR(60) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE sessions (
+    token TEXT PRIMARY KEY,
+    data BLOB NOT NULL,
+    expiry REAL NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON sessions(expiry);
+
+INSERT INTO users (name, email, hashed_password, created) VALUES (
+    'Alice Jones',
+    'alice@example.com',
+    '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG',
+    '2022-01-01 10:00:00'
+);
+
+INSERT INTO snippets (title, content, created, expires) VALUES (
+    'An old silent pond',
+    'An old silent pond...',
+    '2022-01-01 10:00:00',
+    '2099-01-01 10:00:00'
+);
+`
+
+/* A database created before there were migrations is upgraded in place */
+func TestBaselineUpgrade(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.Exec(baselineSchema)
+	assert.NilError(t, err)
+
+	all, err := All(database.SQLite)
+	assert.NilError(t, err)
+
+	done, err := Up(db)
+	assert.NilError(t, err)
+	assert.Equal(t, len(done), len(all))
+
+	var username, hashedPassword string
+	err = db.QueryRow("SELECT username, hashed_password FROM users WHERE email = 'alice@example.com'").Scan(&username, &hashedPassword)
+	assert.NilError(t, err)
+	assert.Equal(t, username, "user1")
+	assert.Equal(t, hashedPassword, "$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG")
+
+	var visibility string
+	var notified bool
+	err = db.QueryRow("SELECT visibility, expiry_notified FROM snippets WHERE id = 1").Scan(&visibility, &notified)
+	assert.NilError(t, err)
+	assert.Equal(t, visibility, "public")
+	assert.Equal(t, notified, false)
+}
+
+/* A version applied to one database means the same on every other one */
+func TestDialectsMatch(t *testing.T) {
+	sqlite, err := All(database.SQLite)
+	assert.NilError(t, err)
+
+	for _, dialect := range database.Dialects {
+		all, err := All(dialect)
+		assert.NilError(t, err)
+		assert.Equal(t, len(all), len(sqlite))
+
+		for i := range all {
+			assert.Equal(t, all[i].String(), sqlite[i].String())
+		}
+	}
+}
+
+/* Snippets which expired before webhooks existed do not fire an event */
+func TestWebhooksExpiryBackfill(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := To(db, 11)
+	assert.NilError(t, err)
+
+	stmt := "INSERT INTO snippets (title, content, created, expires) VALUES (?, ?, ?, ?)"
+	now := time.Now().UTC()
+
+	_, err = db.Exec(stmt, "Expired", "Expired", now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	assert.NilError(t, err)
+
+	_, err = db.Exec(stmt, "Current", "Current", now, now.Add(24*time.Hour))
+	assert.NilError(t, err)
+
+	_, err = To(db, 12)
+	assert.NilError(t, err)
+
+	rows, err := db.Query("SELECT title FROM snippets WHERE expiry_notified = false")
+	assert.NilError(t, err)
+	defer rows.Close()
+
+	titles := []string{}
+	for rows.Next() {
+		var title string
+		assert.NilError(t, rows.Scan(&title))
+		titles = append(titles, title)
+	}
+	assert.NilError(t, rows.Err())
+
+	assert.Equal(t, strings.Join(titles, " "), "Current")
+}
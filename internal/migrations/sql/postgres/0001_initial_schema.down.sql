@@ -1,3 +1,5 @@
+DROP TABLE sessions;
+
 DROP TABLE snippets;
 
 DROP TABLE users;
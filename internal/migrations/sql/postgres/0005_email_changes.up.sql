@@ -0,0 +1,7 @@
+CREATE TABLE email_changes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    hashed_token BYTEA NOT NULL UNIQUE,
+    expires TIMESTAMPTZ NOT NULL
+);
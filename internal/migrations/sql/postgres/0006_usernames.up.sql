@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN username VARCHAR(30);
+
+-- Accounts created before usernames existed get user<id>, which passes the
+-- signup rules and is unique because the ids are
+UPDATE users SET username = 'user' || id;
+
+ALTER TABLE users ALTER COLUMN username SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
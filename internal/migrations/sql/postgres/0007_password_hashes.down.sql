@@ -0,0 +1,2 @@
+-- Fails rather than truncating once there are argon2id hashes
+ALTER TABLE users ALTER COLUMN hashed_password TYPE CHAR(60);
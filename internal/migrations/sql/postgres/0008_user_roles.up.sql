@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT false;
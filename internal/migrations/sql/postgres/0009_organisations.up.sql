@@ -0,0 +1,28 @@
+CREATE TABLE organisations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(30) NOT NULL UNIQUE,
+    created TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE organisation_members (
+    organisation_id INTEGER NOT NULL REFERENCES organisations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL,
+    joined TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (organisation_id, user_id)
+);
+
+CREATE TABLE organisation_invitations (
+    id SERIAL PRIMARY KEY,
+    organisation_id INTEGER NOT NULL REFERENCES organisations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    hashed_token BYTEA NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE snippets ADD COLUMN organisation_id INTEGER REFERENCES organisations(id) ON DELETE SET NULL;
+ALTER TABLE snippets ADD COLUMN visibility VARCHAR(16) NOT NULL DEFAULT 'public';
+
+CREATE INDEX idx_snippets_organisation_id ON snippets(organisation_id);
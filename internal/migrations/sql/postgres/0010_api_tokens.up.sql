@@ -0,0 +1,12 @@
+CREATE TABLE api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    hashed_token BYTEA NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    last_used TIMESTAMPTZ,
+    expires TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
@@ -0,0 +1,5 @@
+ALTER TABLE snippets DROP COLUMN expiry_notified;
+
+DROP TABLE webhook_deliveries;
+
+DROP TABLE webhooks;
@@ -0,0 +1,5 @@
+DROP TABLE sessions;
+
+DROP TABLE snippets;
+
+DROP TABLE users;
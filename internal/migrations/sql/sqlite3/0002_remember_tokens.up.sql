@@ -0,0 +1,11 @@
+CREATE TABLE remember_tokens (
+    id INTEGER NOT NULL PRIMARY KEY,
+    selector CHAR(16) NOT NULL UNIQUE,
+    hashed_validator BLOB NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family CHAR(16) NOT NULL,
+    rotated_at DATETIME,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_remember_tokens_family ON remember_tokens(family);
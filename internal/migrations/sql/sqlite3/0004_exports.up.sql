@@ -0,0 +1,7 @@
+CREATE TABLE exports (
+    id INTEGER NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL,
+    created DATETIME NOT NULL,
+    data BLOB
+);
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN username VARCHAR(30) NOT NULL DEFAULT '';
+
+-- Accounts created before usernames existed get user<id>, which passes the
+-- signup rules and is unique because the ids are
+UPDATE users SET username = 'user' || id;
+
+CREATE UNIQUE INDEX idx_users_username ON users(username);
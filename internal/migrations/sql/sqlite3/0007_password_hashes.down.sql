@@ -0,0 +1,3 @@
+-- SQLite does not enforce the length of CHAR(60), argon2id hashes fit in
+-- the column as it is. This keeps the versions in step with postgres.
+SELECT 1;
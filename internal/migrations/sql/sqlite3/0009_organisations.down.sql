@@ -0,0 +1,10 @@
+DROP INDEX idx_snippets_organisation_id;
+
+ALTER TABLE snippets DROP COLUMN visibility;
+ALTER TABLE snippets DROP COLUMN organisation_id;
+
+DROP TABLE organisation_invitations;
+
+DROP TABLE organisation_members;
+
+DROP TABLE organisations;
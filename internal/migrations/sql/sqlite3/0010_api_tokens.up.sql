@@ -0,0 +1,12 @@
+CREATE TABLE api_tokens (
+    id INTEGER NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    hashed_token BLOB NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME,
+    expires DATETIME
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
@@ -0,0 +1,34 @@
+CREATE TABLE webhooks (
+    id INTEGER NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    events VARCHAR(255) NOT NULL,
+    all_snippets BOOLEAN NOT NULL DEFAULT false,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_webhooks_user_id ON webhooks(user_id);
+
+CREATE TABLE webhook_deliveries (
+    id INTEGER NOT NULL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event VARCHAR(32) NOT NULL,
+    payload BLOB NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt DATETIME NOT NULL,
+    last_attempt DATETIME,
+    response_status INTEGER,
+    last_error TEXT NOT NULL DEFAULT '',
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+
+ALTER TABLE snippets ADD COLUMN expiry_notified BOOLEAN NOT NULL DEFAULT false;
+
+-- Snippets which expired before there were webhooks must not all fire a
+-- snippet.expired event as soon as the worker starts
+UPDATE snippets SET expiry_notified = true WHERE expires <= CURRENT_TIMESTAMP;
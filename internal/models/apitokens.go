@@ -0,0 +1,206 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+const (
+	ScopeSnippetsRead  = "snippets:read"
+	ScopeSnippetsWrite = "snippets:write"
+)
+
+/* Prefix of every token, makes them easy to spot in logs and secret scanners */
+const apiTokenPrefix = "sbx_"
+
+type APITokenModelInterface interface {
+	New(ctx context.Context, userID int, name string, scopes []string, expires time.Time) (*APIToken, error)
+	Authenticate(ctx context.Context, token string) (*APIToken, error)
+	ForUser(ctx context.Context, userID int) ([]*APIToken, error)
+	Revoke(ctx context.Context, userID, id int) error
+}
+
+type APIToken struct {
+	ID     int
+	UserID int
+	Name   string
+	Scopes []string
+	/* Only set on a freshly created token, it is never read back from the db */
+	Plaintext string
+	Created   time.Time
+	/* Zero if the token has never been used */
+	LastUsed time.Time
+	/* Zero if the token never expires */
+	Expires time.Time
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidScope(scope string) bool {
+	return scope == ScopeSnippetsRead || scope == ScopeSnippetsWrite
+}
+
+type APITokenModel struct {
+	DB *database.DB
+}
+
+// Creates a token for the user, expires may be the zero time for a token
+// which never expires. Tokens are long and random enough that a plain
+// sha256 hash is sufficient, unlike passwords.
+func (m *APITokenModel) New(ctx context.Context, userID int, name string, scopes []string, expires time.Time) (*APIToken, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		Plaintext: apiTokenPrefix + secret,
+		Created:   time.Now().UTC(),
+		Expires:   expires.UTC(),
+	}
+
+	stmt := `INSERT INTO api_tokens (user_id, name, hashed_token, scopes, created, expires)
+	VALUES (?, ?, ?, ?, ?, ?) RETURNING id`
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID, name, hashValidator(token.Plaintext), strings.Join(scopes, " "), token.Created, nullTime(token.Expires)).Scan(&token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Looks up the token and records that it has been used. Expired tokens and
+// tokens belonging to disabled users are rejected with ErrInvalidCredentials.
+func (m *APITokenModel) Authenticate(ctx context.Context, token string) (*APIToken, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+
+	stmt := `SELECT t.id, t.user_id, t.name, t.scopes, t.created, t.last_used, t.expires FROM api_tokens t
+	INNER JOIN users u ON u.id = t.user_id
+	WHERE t.hashed_token = ? AND u.disabled = false`
+
+	t, err := scanAPIToken(m.DB.QueryRowContext(ctx, stmt, hashValidator(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		} else {
+			return nil, err
+		}
+	}
+
+	if !t.Expires.IsZero() && time.Now().After(t.Expires) {
+		return nil, ErrInvalidCredentials
+	}
+
+	t.LastUsed = time.Now().UTC()
+
+	stmt = "UPDATE api_tokens SET last_used = ? WHERE id = ?"
+	_, err = m.DB.ExecContext(ctx, stmt, t.LastUsed, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+/* All of the users tokens including expired ones, most recent first */
+func (m *APITokenModel) ForUser(ctx context.Context, userID int) ([]*APIToken, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, user_id, name, scopes, created, last_used, expires FROM api_tokens
+	WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*APIToken{}
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+/* ErrNoRecord if the token does not exist or belongs to somebody else */
+func (m *APITokenModel) Revoke(ctx context.Context, userID, id int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "DELETE FROM api_tokens WHERE id = ? AND user_id = ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func scanAPIToken(row scanner) (*APIToken, error) {
+	t := &APIToken{}
+
+	var scopes string
+	var lastUsed, expires sql.NullTime
+
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &lastUsed, &expires)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	t.LastUsed = lastUsed.Time
+	t.Expires = expires.Time
+
+	return t, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+func TestAPITokenAuthenticate(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		tests := []struct {
+			name    string
+			expires time.Time
+			revoke  bool
+			wantErr error
+		}{
+			{
+				name: "Never expires",
+			},
+			{
+				name:    "Not yet expired",
+				expires: time.Now().Add(time.Hour),
+			},
+			{
+				name:    "Expired",
+				expires: time.Now().Add(-time.Hour),
+				wantErr: ErrInvalidCredentials,
+			},
+			{
+				name:    "Revoked",
+				revoke:  true,
+				wantErr: ErrInvalidCredentials,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				db := newTestDB(t, dialect)
+				m := APITokenModel{DB: db}
+
+				// Alice is user 1 in testdata/setup.sql
+				token, err := m.New(t.Context(), 1, "script", []string{ScopeSnippetsRead}, tt.expires)
+				assert.NilError(t, err)
+
+				if tt.revoke {
+					err = m.Revoke(t.Context(), 1, token.ID)
+					assert.NilError(t, err)
+				}
+
+				got, err := m.Authenticate(t.Context(), token.Plaintext)
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+
+				if tt.wantErr == nil {
+					assert.Equal(t, got.UserID, 1)
+					assert.Equal(t, got.HasScope(ScopeSnippetsRead), true)
+					assert.Equal(t, got.HasScope(ScopeSnippetsWrite), false)
+
+					tokens, err := m.ForUser(t.Context(), 1)
+					assert.NilError(t, err)
+					assert.Equal(t, len(tokens), 1)
+					assert.Equal(t, tokens[0].LastUsed.IsZero(), false)
+				}
+			})
+		}
+	})
+}
+
+func TestAPITokenRevokeOtherUser(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := APITokenModel{DB: db}
+
+		token, err := m.New(t.Context(), 1, "script", []string{ScopeSnippetsRead}, time.Time{})
+		assert.NilError(t, err)
+
+		err = m.Revoke(t.Context(), 2, token.ID)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
@@ -10,4 +10,13 @@ var (
 	/* Error for managing user login */
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrDuplicateUsername  = errors.New("models: duplicate username")
+	ErrAccountDisabled    = errors.New("models: account disabled")
+	ErrDuplicateSlug      = errors.New("models: duplicate slug")
+
+	/* A new export was asked for within ExportInterval of the last one */
+	ErrRecentExport = errors.New("models: export requested recently")
+
+	/* A remember token was presented again after its grace period had passed */
+	ErrTokenReused = errors.New("models: remember token reused")
 )
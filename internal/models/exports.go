@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+/* How long an export can be downloaded for, after that it is deleted */
+const ExportLifetime = 7 * 24 * time.Hour
+
+// How long a user has to wait between exports, each of which is built in
+// memory and stored in the db. Failed exports do not count.
+const ExportInterval = time.Hour
+
+type ExportModelInterface interface {
+	Insert(ctx context.Context, userID int) (int, error)
+	Complete(ctx context.Context, id int, data []byte) error
+	Fail(ctx context.Context, id int) error
+	Latest(ctx context.Context, userID int) (*Export, error)
+	Get(ctx context.Context, id, userID int) (*Export, error)
+	DeleteExpired(ctx context.Context) (int, error)
+}
+
+type Export struct {
+	ID      int
+	UserID  int
+	Status  string
+	Created time.Time
+	Expires time.Time
+	/* The zip archive, only loaded by Get */
+	Data []byte
+}
+
+type ExportModel struct {
+	DB *database.DB
+}
+
+// Adds a pending export for the user. ErrRecentExport means they have asked
+// for one within the last ExportInterval already.
+func (m *ExportModel) Insert(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	/* INFO: Rollback is a no-op once the transaction has been committed */
+	defer tx.Rollback()
+
+	/* INFO: Locks the users row, so that of several requests sent at once
+	   only one gets past the check below */
+	stmt := "UPDATE users SET id = id WHERE id = ?"
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+
+	var recent int
+
+	stmt = "SELECT COUNT(*) FROM exports WHERE user_id = ? AND status <> ? AND created > ?"
+	err = tx.QueryRowContext(ctx, stmt, userID, ExportFailed, now.Add(-ExportInterval)).Scan(&recent)
+	if err != nil {
+		return 0, err
+	}
+
+	if recent > 0 {
+		return 0, ErrRecentExport
+	}
+
+	var id int
+
+	stmt = "INSERT INTO exports (user_id, status, created) VALUES (?, ?, ?) RETURNING id"
+
+	err = tx.QueryRowContext(ctx, stmt, userID, ExportPending, now).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (m *ExportModel) Complete(ctx context.Context, id int, data []byte) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "UPDATE exports SET status = ?, data = ? WHERE id = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, ExportReady, data, id)
+	return err
+}
+
+func (m *ExportModel) Fail(ctx context.Context, id int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "UPDATE exports SET status = ? WHERE id = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, ExportFailed, id)
+	return err
+}
+
+/* The most recently requested export for the user which has not expired, without its data */
+func (m *ExportModel) Latest(ctx context.Context, userID int) (*Export, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	e := &Export{}
+
+	stmt := "SELECT id, user_id, status, created FROM exports WHERE user_id = ? AND created > ? ORDER BY id DESC LIMIT 1"
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID, time.Now().Add(-ExportLifetime).UTC()).Scan(&e.ID, &e.UserID, &e.Status, &e.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	e.Expires = e.Created.Add(ExportLifetime)
+
+	return e, nil
+}
+
+/* Only returns the export if it belongs to userID and has not expired */
+func (m *ExportModel) Get(ctx context.Context, id, userID int) (*Export, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	e := &Export{}
+
+	stmt := "SELECT id, user_id, status, created, data FROM exports WHERE id = ? AND user_id = ? AND created > ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, id, userID, time.Now().Add(-ExportLifetime).UTC()).Scan(&e.ID, &e.UserID, &e.Status, &e.Created, &e.Data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	e.Expires = e.Created.Add(ExportLifetime)
+
+	return e, nil
+}
+
+// Deletes the exports which are older than ExportLifetime, archives and all,
+// returning how many there were.
+func (m *ExportModel) DeleteExpired(ctx context.Context) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "DELETE FROM exports WHERE created <= ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now().Add(-ExportLifetime).UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+func TestExportExpiry(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := ExportModel{DB: db}
+
+		// Alice is user 1 in testdata/setup.sql
+		oldID, err := m.Insert(t.Context(), 1)
+		assert.NilError(t, err)
+		err = m.Complete(t.Context(), oldID, []byte("old"))
+		assert.NilError(t, err)
+
+		_, err = db.Exec("UPDATE exports SET created = ? WHERE id = ?", time.Now().Add(-ExportLifetime-time.Hour).UTC(), oldID)
+		assert.NilError(t, err)
+
+		// Expired exports can no longer be downloaded, even before they are deleted
+		_, err = m.Get(t.Context(), oldID, 1)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+		_, err = m.Latest(t.Context(), 1)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+		newID, err := m.Insert(t.Context(), 1)
+		assert.NilError(t, err)
+
+		n, err := m.DeleteExpired(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, n, 1)
+
+		e, err := m.Latest(t.Context(), 1)
+		assert.NilError(t, err)
+		assert.Equal(t, e.ID, newID)
+		assert.Equal(t, e.Expires.Equal(e.Created.Add(ExportLifetime)), true)
+
+		var remaining int
+		err = db.QueryRow("SELECT COUNT(*) FROM exports").Scan(&remaining)
+		assert.NilError(t, err)
+		assert.Equal(t, remaining, 1)
+	})
+}
+
+func TestExportInterval(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := ExportModel{DB: db}
+
+		// Alice is user 1 in testdata/setup.sql. Of several requests sent at
+		// once only one gets an export
+		var wg sync.WaitGroup
+		errs := make([]error, 4)
+
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = m.Insert(t.Context(), 1)
+			}()
+		}
+		wg.Wait()
+
+		inserted := 0
+		for _, err := range errs {
+			if err == nil {
+				inserted++
+			} else {
+				assert.Equal(t, errors.Is(err, ErrRecentExport), true)
+			}
+		}
+		assert.Equal(t, inserted, 1)
+
+		e, err := m.Latest(t.Context(), 1)
+		assert.NilError(t, err)
+
+		/* A failed export can be tried again straight away */
+		err = m.Fail(t.Context(), e.ID)
+		assert.NilError(t, err)
+
+		_, err = m.Insert(t.Context(), 1)
+		assert.NilError(t, err)
+
+		_, err = m.Insert(t.Context(), 1)
+		assert.Equal(t, errors.Is(err, ErrRecentExport), true)
+	})
+}
@@ -0,0 +1,56 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+type APITokenModel struct{}
+
+func (m *APITokenModel) New(ctx context.Context, userID int, name string, scopes []string, expires time.Time) (*models.APIToken, error) {
+	return &models.APIToken{
+		ID:        1,
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		Plaintext: "sbx_new",
+		Created:   time.Now(),
+		Expires:   expires,
+	}, nil
+}
+
+/* "sbx_read" and "sbx_write" belong to Bob, "sbx_alice" to Alice, everything else is invalid */
+func (m *APITokenModel) Authenticate(ctx context.Context, token string) (*models.APIToken, error) {
+	switch token {
+	case "sbx_read":
+		return &models.APIToken{ID: 1, UserID: 1, Name: "read", Scopes: []string{models.ScopeSnippetsRead}}, nil
+	case "sbx_write":
+		return &models.APIToken{ID: 2, UserID: 1, Name: "write", Scopes: []string{models.ScopeSnippetsRead, models.ScopeSnippetsWrite}}, nil
+	case "sbx_alice":
+		return &models.APIToken{ID: 3, UserID: 2, Name: "alice", Scopes: []string{models.ScopeSnippetsRead, models.ScopeSnippetsWrite}}, nil
+	case "sbx_carol":
+		return &models.APIToken{ID: 4, UserID: 3, Name: "carol", Scopes: []string{models.ScopeSnippetsRead}}, nil
+	default:
+		return nil, models.ErrInvalidCredentials
+	}
+}
+
+func (m *APITokenModel) ForUser(ctx context.Context, userID int) ([]*models.APIToken, error) {
+	if userID == 1 {
+		return []*models.APIToken{
+			{ID: 1, UserID: 1, Name: "read", Scopes: []string{models.ScopeSnippetsRead}, Created: time.Now(), LastUsed: time.Now()},
+		}, nil
+	}
+
+	return []*models.APIToken{}, nil
+}
+
+func (m *APITokenModel) Revoke(ctx context.Context, userID, id int) error {
+	if userID == 1 && id == 1 {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
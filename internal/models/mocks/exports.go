@@ -0,0 +1,56 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+var mockExport = &models.Export{
+	ID:      1,
+	UserID:  1,
+	Status:  models.ExportReady,
+	Created: time.Now(),
+	Expires: time.Now().Add(models.ExportLifetime),
+	Data:    []byte("PK"),
+}
+
+type ExportModel struct{}
+
+func (m *ExportModel) Insert(ctx context.Context, userID int) (int, error) {
+	/* Bob's export is only just made */
+	if userID == 1 {
+		return 0, models.ErrRecentExport
+	}
+
+	return 2, nil
+}
+
+func (m *ExportModel) Complete(ctx context.Context, id int, data []byte) error {
+	return nil
+}
+
+func (m *ExportModel) Fail(ctx context.Context, id int) error {
+	return nil
+}
+
+func (m *ExportModel) Latest(ctx context.Context, userID int) (*models.Export, error) {
+	if userID == 1 {
+		return mockExport, nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *ExportModel) Get(ctx context.Context, id, userID int) (*models.Export, error) {
+	if id == 1 && userID == 1 {
+		return mockExport, nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *ExportModel) DeleteExpired(ctx context.Context) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,74 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+var mockOrganisation = &models.Organisation{
+	ID:      1,
+	Name:    "Acme",
+	Slug:    "acme",
+	Created: time.Now(),
+}
+
+type OrganisationModel struct{}
+
+func (m *OrganisationModel) Insert(ctx context.Context, name, slug string, ownerID int) (int, error) {
+	if slug == "acme" {
+		return 0, models.ErrDuplicateSlug
+	}
+
+	return 2, nil
+}
+
+func (m *OrganisationModel) GetBySlug(ctx context.Context, slug string) (*models.Organisation, error) {
+	if slug == "acme" {
+		return mockOrganisation, nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *OrganisationModel) ForUser(ctx context.Context, userID int) ([]*models.Organisation, error) {
+	if userID == 1 {
+		o := *mockOrganisation
+		o.Role = models.OrganisationOwner
+		return []*models.Organisation{&o}, nil
+	}
+
+	return []*models.Organisation{}, nil
+}
+
+func (m *OrganisationModel) Members(ctx context.Context, organisationID int) ([]*models.Member, error) {
+	if organisationID == 1 {
+		return []*models.Member{
+			{UserID: 1, Name: "Bob", Username: "bob", Role: models.OrganisationOwner, Joined: time.Now()},
+		}, nil
+	}
+
+	return []*models.Member{}, nil
+}
+
+/* Bob owns Acme, nobody else is a member of anything */
+func (m *OrganisationModel) MemberRole(ctx context.Context, organisationID, userID int) (string, error) {
+	if organisationID == 1 && userID == 1 {
+		return models.OrganisationOwner, nil
+	}
+
+	return "", models.ErrNoRecord
+}
+
+func (m *OrganisationModel) Invite(ctx context.Context, organisationID int, email string, invitedBy int) (string, error) {
+	return "invitation", nil
+}
+
+func (m *OrganisationModel) AcceptInvitation(ctx context.Context, token string, userID int) (*models.Organisation, error) {
+	if token == "invitation" {
+		return mockOrganisation, nil
+	}
+
+	return nil, models.ErrNoRecord
+}
@@ -0,0 +1,63 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+type RememberTokenModel struct{}
+
+func (m *RememberTokenModel) New(ctx context.Context, userID int, family string, lifetime time.Duration) (*models.RememberToken, error) {
+	return &models.RememberToken{
+		Selector:  "selector",
+		Validator: "validator",
+		UserID:    userID,
+		Family:    family,
+		Expires:   time.Now().Add(lifetime),
+	}, nil
+}
+
+func (m *RememberTokenModel) Consume(ctx context.Context, selector, validator string) (*models.RememberToken, error) {
+	switch {
+	case selector == "selector" && validator == "validator":
+		return &models.RememberToken{
+			Selector: selector,
+			UserID:   1,
+			Family:   "family",
+			Expires:  time.Now().Add(time.Hour),
+		}, nil
+	case selector == "concurrent" && validator == "validator":
+		return &models.RememberToken{
+			Selector: selector,
+			UserID:   1,
+			Family:   "family",
+			Expires:  time.Now().Add(time.Hour),
+			Rotated:  true,
+		}, nil
+	case selector == "carol" && validator == "validator":
+		return &models.RememberToken{
+			Selector: selector,
+			UserID:   3,
+			Family:   "family",
+			Expires:  time.Now().Add(time.Hour),
+		}, nil
+	case selector == "rotated":
+		return nil, models.ErrTokenReused
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *RememberTokenModel) Revoke(ctx context.Context, selector string) error {
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID int) error {
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteExpired(ctx context.Context) (int, error) {
+	return 0, nil
+}
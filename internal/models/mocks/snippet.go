@@ -1,34 +1,127 @@
 package mocks
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/mohafarman/snippetbox/internal/models"
 )
 
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:         1,
+	Title:      "An old silent pond",
+	Content:    "An old silent pond...",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     1,
+	Visibility: models.VisibilityPublic,
+}
+
+var mockPrivateSnippet = &models.Snippet{
+	ID:         3,
+	Title:      "A private note",
+	Content:    "Only for Bob",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	UserID:     1,
+	Visibility: models.VisibilityPrivate,
+}
+
+var mockTeamSnippet = &models.Snippet{
+	ID:             4,
+	Title:          "A team note",
+	Content:        "Only for Acme",
+	Created:        time.Now(),
+	Expires:        time.Now(),
+	UserID:         1,
+	OrganisationID: 1,
+	Visibility:     models.VisibilityTeam,
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, content string, language string, expires int, visibility string, organisationID int) (int, error) {
 	return 2, nil
 }
 
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
+	case 3:
+		return mockPrivateSnippet, nil
+	case 4:
+		return mockTeamSnippet, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+func (m *SnippetModel) Latest(ctx context.Context) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) AllForUser(ctx context.Context, userID int) ([]*models.Snippet, error) {
+	if userID == 1 {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) ForUser(ctx context.Context, userID int) ([]*models.Snippet, error) {
+	return m.AllForUser(ctx, userID)
+}
+
+func (m *SnippetModel) ForOrganisation(ctx context.Context, organisationID, viewerID int) ([]*models.Snippet, error) {
+	if organisationID == 1 {
+		return []*models.Snippet{mockTeamSnippet}, nil
+	}
+
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) Browse(ctx context.Context, limit, offset int) ([]*models.Snippet, error) {
 	return []*models.Snippet{mockSnippet}, nil
 }
+
+func (m *SnippetModel) Public(ctx context.Context, search string, limit, offset int) ([]*models.Snippet, error) {
+	if offset > 0 || !strings.Contains(strings.ToLower(mockSnippet.Title), strings.ToLower(search)) {
+		return []*models.Snippet{}, nil
+	}
+
+	return []*models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, content string, language string, expires time.Time, visibility string, organisationID int) error {
+	switch id {
+	case 1, 3, 4:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	if id == 1 {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+/* Always returns the same expired snippet of Bob's */
+func (m *SnippetModel) TakeExpired(ctx context.Context) ([]*models.Snippet, error) {
+	return []*models.Snippet{
+		{
+			ID:         5,
+			Title:      "A forgotten note",
+			Content:    "Gone now",
+			Created:    time.Now().AddDate(0, 0, -8),
+			Expires:    time.Now().AddDate(0, 0, -1),
+			UserID:     1,
+			Visibility: models.VisibilityPublic,
+		},
+	}, nil
+}
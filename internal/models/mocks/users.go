@@ -1,52 +1,162 @@
 package mocks
 
 import (
+	"context"
 	"github.com/mohafarman/snippetbox/internal/models"
 	"time"
 )
 
 type UserModel struct{}
 
-func (m *UserModel) Insert(name, email, password string) error {
-	switch email {
-	case "dupe@example.com":
+func (m *UserModel) Insert(ctx context.Context, name, username, email, password string) error {
+	switch {
+	case email == "dupe@example.com":
 		return models.ErrDuplicateEmail
+	case username == "dupe":
+		return models.ErrDuplicateUsername
 	default:
 		return nil
 	}
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "bob@example.com" && password == "password" {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	switch {
+	case email == "bob@example.com" && password == "password":
 		return 1, nil
+	case email == "alice@example.com" && password == "password":
+		return 2, nil
+	case email == "carol@example.com" && password == "password":
+		return 3, nil
+	default:
+		return 0, models.ErrInvalidCredentials
 	}
-
-	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, 2, 3:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
 
-func (m *UserModel) Get(id int) (*models.User, error) {
-	if id == 1 {
-		u := &models.User{
-			ID:      1,
-			Name:    "Bob Jones",
-			Email:   "bob@example.com",
-			Created: time.Now(),
-		}
-		return u, nil
+var mockUser = &models.User{
+	ID:       1,
+	Name:     "Bob Jones",
+	Username: "bob",
+	Email:    "bob@example.com",
+	Created:  time.Now(),
+	Role:     models.RoleUser,
+}
+
+var mockAdmin = &models.User{
+	ID:       2,
+	Name:     "Alice Jones",
+	Username: "alice",
+	Email:    "alice@example.com",
+	Created:  time.Now(),
+	Role:     models.RoleAdmin,
+}
+
+/* An admin has asked her to choose a new password */
+var mockResetUser = &models.User{
+	ID:                    3,
+	Name:                  "Carol Jones",
+	Username:              "carol",
+	Email:                 "carol@example.com",
+	Created:               time.Now(),
+	Role:                  models.RoleUser,
+	PasswordResetRequired: true,
+}
+
+func (m *UserModel) Get(ctx context.Context, id int) (*models.User, error) {
+	switch id {
+	case 1:
+		return mockUser, nil
+	case 2:
+		return mockAdmin, nil
+	case 3:
+		return mockResetUser, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	switch email {
+	case "bob@example.com":
+		return mockUser, nil
+	case "alice@example.com":
+		return mockAdmin, nil
+	case "carol@example.com":
+		return mockResetUser, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) List(ctx context.Context, search string, limit, offset int) ([]*models.User, error) {
+	return []*models.User{mockAdmin, mockUser}, nil
+}
+
+func (m *UserModel) SetRole(ctx context.Context, id int, role string) error {
+	return m.exists(ctx, id)
+}
+
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	return m.exists(ctx, id)
+}
+
+func (m *UserModel) RequirePasswordReset(ctx context.Context, id int) error {
+	return m.exists(ctx, id)
+}
+
+func (m *UserModel) exists(ctx context.Context, id int) error {
+	if _, err := m.Get(ctx, id); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *UserModel) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	if username == "bob" {
+		return mockUser, nil
 	}
 
 	return nil, models.ErrNoRecord
 }
 
-func (m *UserModel) CompareAndUpdatePassword(id int, currentPassword, newPassword string) (bool, error) {
+func (m *UserModel) CompareAndUpdatePassword(ctx context.Context, id int, currentPassword, newPassword string) (bool, error) {
 	return true, nil
 }
+
+func (m *UserModel) Delete(ctx context.Context, id int, password string, keepSnippets bool) error {
+	if id == 1 && password == "password" {
+		return nil
+	}
+
+	return models.ErrInvalidCredentials
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	return nil
+}
+
+func (m *UserModel) RequestEmailChange(ctx context.Context, id int, newEmail string) (string, error) {
+	switch newEmail {
+	case "dupe@example.com":
+		return "", models.ErrDuplicateEmail
+	default:
+		return "token", nil
+	}
+}
+
+func (m *UserModel) ConfirmEmailChange(ctx context.Context, token string) (int, error) {
+	if token == "token" {
+		return 1, nil
+	}
+
+	return 0, models.ErrNoRecord
+}
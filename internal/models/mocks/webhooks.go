@@ -0,0 +1,170 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/models"
+)
+
+// Bob has a single webhook, 1, subscribed to every event. Unlike the other
+// mocks the outbox is kept in memory, so that tests can follow a delivery
+// from the request which queued it through to the receiver.
+type WebhookModel struct {
+	/* Where Bob's webhook points, so tests can aim it at a local receiver */
+	URL string
+
+	mu         sync.Mutex
+	deliveries []*models.WebhookDelivery
+}
+
+func (m *WebhookModel) webhook() *models.Webhook {
+	url := m.URL
+	if url == "" {
+		url = "https://example.com/hook"
+	}
+
+	return &models.Webhook{
+		ID:      1,
+		UserID:  1,
+		URL:     url,
+		Secret:  "whsec_test",
+		Events:  models.WebhookEvents,
+		Created: time.Now(),
+	}
+}
+
+func (m *WebhookModel) Insert(ctx context.Context, userID int, url string, events []string, allSnippets bool) (*models.Webhook, error) {
+	return &models.Webhook{
+		ID:          2,
+		UserID:      userID,
+		URL:         url,
+		Secret:      "whsec_new",
+		Events:      events,
+		AllSnippets: allSnippets,
+		Created:     time.Now(),
+	}, nil
+}
+
+func (m *WebhookModel) Get(ctx context.Context, id, userID int) (*models.Webhook, error) {
+	if id == 1 && userID == 1 {
+		return m.webhook(), nil
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *WebhookModel) ForUser(ctx context.Context, userID int) ([]*models.Webhook, error) {
+	if userID == 1 {
+		return []*models.Webhook{m.webhook()}, nil
+	}
+
+	return []*models.Webhook{}, nil
+}
+
+func (m *WebhookModel) Delete(ctx context.Context, id, userID int) error {
+	if id == 1 && userID == 1 {
+		return nil
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *WebhookModel) Subscribed(ctx context.Context, event string, ownerID int) ([]*models.Webhook, error) {
+	if ownerID == 1 {
+		return []*models.Webhook{m.webhook()}, nil
+	}
+
+	return []*models.Webhook{}, nil
+}
+
+func (m *WebhookModel) Enqueue(ctx context.Context, webhookID int, event string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries = append(m.deliveries, &models.WebhookDelivery{
+		ID:          len(m.deliveries) + 1,
+		WebhookID:   webhookID,
+		Event:       event,
+		Payload:     payload,
+		Status:      models.DeliveryPending,
+		NextAttempt: time.Now(),
+		Created:     time.Now(),
+	})
+
+	return nil
+}
+
+func (m *WebhookModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []*models.WebhookDelivery{}
+
+	for _, d := range m.deliveries {
+		claimable := d.Status == models.DeliveryPending || d.Status == models.DeliverySending
+		if len(due) < limit && claimable && !d.NextAttempt.After(time.Now()) {
+			d.Status = models.DeliverySending
+			d.NextAttempt = time.Now().Add(lease)
+
+			delivery := *d
+			delivery.URL = m.webhook().URL
+			delivery.Secret = m.webhook().Secret
+			due = append(due, &delivery)
+		}
+	}
+
+	return due, nil
+}
+
+func (m *WebhookModel) Delivered(ctx context.Context, id, responseStatus int) error {
+	return m.attempt(id, models.DeliveryDelivered, responseStatus, "", time.Now())
+}
+
+func (m *WebhookModel) Retry(ctx context.Context, id, responseStatus int, lastError string, next time.Time) error {
+	return m.attempt(id, models.DeliveryPending, responseStatus, lastError, next)
+}
+
+func (m *WebhookModel) Fail(ctx context.Context, id, responseStatus int, lastError string) error {
+	return m.attempt(id, models.DeliveryFailed, responseStatus, lastError, time.Now())
+}
+
+func (m *WebhookModel) Deliveries(ctx context.Context, webhookID, limit int) ([]*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := []*models.WebhookDelivery{}
+
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			delivery := *m.deliveries[i]
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (m *WebhookModel) Prune(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (m *WebhookModel) attempt(id int, status string, responseStatus int, lastError string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.deliveries) {
+		return models.ErrNoRecord
+	}
+
+	d := m.deliveries[id-1]
+	d.Status = status
+	d.Attempts++
+	d.LastAttempt = time.Now()
+	d.ResponseStatus = responseStatus
+	d.LastError = lastError
+	d.NextAttempt = next
+
+	return nil
+}
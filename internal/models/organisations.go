@@ -0,0 +1,279 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+const (
+	OrganisationOwner  = "owner"
+	OrganisationMember = "member"
+)
+
+type OrganisationModelInterface interface {
+	Insert(ctx context.Context, name, slug string, ownerID int) (int, error)
+	GetBySlug(ctx context.Context, slug string) (*Organisation, error)
+	ForUser(ctx context.Context, userID int) ([]*Organisation, error)
+	Members(ctx context.Context, organisationID int) ([]*Member, error)
+	MemberRole(ctx context.Context, organisationID, userID int) (string, error)
+	Invite(ctx context.Context, organisationID int, email string, invitedBy int) (string, error)
+	AcceptInvitation(ctx context.Context, token string, userID int) (*Organisation, error)
+}
+
+type Organisation struct {
+	ID      int
+	Name    string
+	Slug    string
+	Created time.Time
+	/* The role of the user it was loaded for, only set by ForUser */
+	Role string
+}
+
+type Member struct {
+	UserID   int
+	Name     string
+	Username string
+	Role     string
+	Joined   time.Time
+}
+
+type OrganisationModel struct {
+	DB *database.DB
+}
+
+/* Creates the organisation with ownerID as its first owner */
+func (m *OrganisationModel) Insert(ctx context.Context, name, slug string, ownerID int) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	var id int
+
+	stmt := "INSERT INTO organisations (name, slug, created) VALUES (?, ?, ?) RETURNING id"
+	err = tx.QueryRowContext(ctx, stmt, name, slug, now).Scan(&id)
+	if err != nil {
+		if _, ok := database.UniqueViolation(err); ok {
+			return 0, ErrDuplicateSlug
+		}
+		return 0, err
+	}
+
+	stmt = "INSERT INTO organisation_members (organisation_id, user_id, role, joined) VALUES (?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, stmt, id, ownerID, OrganisationOwner, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (m *OrganisationModel) GetBySlug(ctx context.Context, slug string) (*Organisation, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	o := &Organisation{}
+
+	stmt := "SELECT id, name, slug, created FROM organisations WHERE slug = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, slug).Scan(&o.ID, &o.Name, &o.Slug, &o.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+/* Every organisation the user is a member of, with their role in it */
+func (m *OrganisationModel) ForUser(ctx context.Context, userID int) ([]*Organisation, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT o.id, o.name, o.slug, o.created, om.role FROM organisations o
+	INNER JOIN organisation_members om ON om.organisation_id = o.id
+	WHERE om.user_id = ? ORDER BY o.name`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	organisations := []*Organisation{}
+
+	for rows.Next() {
+		o := &Organisation{}
+		err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.Created, &o.Role)
+		if err != nil {
+			return nil, err
+		}
+		organisations = append(organisations, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return organisations, nil
+}
+
+func (m *OrganisationModel) Members(ctx context.Context, organisationID int) ([]*Member, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT u.id, u.name, u.username, om.role, om.joined FROM organisation_members om
+	INNER JOIN users u ON u.id = om.user_id
+	WHERE om.organisation_id = ? ORDER BY om.joined`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, organisationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := []*Member{}
+
+	for rows.Next() {
+		mb := &Member{}
+		err := rows.Scan(&mb.UserID, &mb.Name, &mb.Username, &mb.Role, &mb.Joined)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, mb)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+/* ErrNoRecord if the user is not a member */
+func (m *OrganisationModel) MemberRole(ctx context.Context, organisationID, userID int) (string, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	var role string
+
+	stmt := "SELECT role FROM organisation_members WHERE organisation_id = ? AND user_id = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, organisationID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+// Stores an invitation for email and returns the token for the link which
+// accepts it. Invitations are valid for a week.
+func (m *OrganisationModel) Invite(ctx context.Context, organisationID int, email string, invitedBy int) (string, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO organisation_invitations (organisation_id, email, hashed_token, invited_by, expires)
+	VALUES (?, ?, ?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, organisationID, strings.ToLower(email), hashValidator(token), invitedBy, time.Now().Add(7*24*time.Hour).UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Makes the user a member of the organisation they were invited to. The
+// invitation has to have been sent to the users own email address, so a
+// forwarded link is of no use to anybody else.
+func (m *OrganisationModel) AcceptInvitation(ctx context.Context, token string, userID int) (*Organisation, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	var invitationID, organisationID int
+	var email string
+	var expires time.Time
+
+	stmt := "SELECT id, organisation_id, email, expires FROM organisation_invitations WHERE hashed_token = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, hashValidator(token)).Scan(&invitationID, &organisationID, &email, &expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	if time.Now().After(expires) {
+		return nil, ErrNoRecord
+	}
+
+	var userEmail string
+
+	stmt = "SELECT email FROM users WHERE id = ?"
+	err = m.DB.QueryRowContext(ctx, stmt, userID).Scan(&userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(userEmail, email) {
+		return nil, ErrNoRecord
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	/* Accepting twice, or after joining some other way, is not an error */
+	stmt = `INSERT INTO organisation_members (organisation_id, user_id, role, joined) VALUES (?, ?, ?, ?)
+	ON CONFLICT (organisation_id, user_id) DO NOTHING`
+	_, err = tx.ExecContext(ctx, stmt, organisationID, userID, OrganisationMember, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = "DELETE FROM organisation_invitations WHERE id = ?"
+	_, err = tx.ExecContext(ctx, stmt, invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Organisation{}
+
+	stmt = "SELECT id, name, slug, created FROM organisations WHERE id = ?"
+	err = tx.QueryRowContext(ctx, stmt, organisationID).Scan(&o.ID, &o.Name, &o.Slug, &o.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	return o, tx.Commit()
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+func TestOrganisationInsert(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := OrganisationModel{DB: db}
+
+		// Alice is user 1 in testdata/setup.sql
+		id, err := m.Insert(t.Context(), "Acme", "acme", 1)
+		assert.NilError(t, err)
+
+		role, err := m.MemberRole(t.Context(), id, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, role, OrganisationOwner)
+
+		_, err = m.Insert(t.Context(), "Acme Again", "acme", 1)
+		assert.Equal(t, errors.Is(err, ErrDuplicateSlug), true)
+
+		organisations, err := m.ForUser(t.Context(), 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(organisations), 1)
+		assert.Equal(t, organisations[0].Role, OrganisationOwner)
+	})
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		tests := []struct {
+			name    string
+			invitee string
+			token   string
+			wantErr error
+		}{
+			{
+				name:    "Valid",
+				invitee: "bob@example.com",
+			},
+			{
+				name:    "Invalid token",
+				invitee: "bob@example.com",
+				token:   "invalid",
+				wantErr: ErrNoRecord,
+			},
+			{
+				name:    "Different email",
+				invitee: "carol@example.com",
+				wantErr: ErrNoRecord,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				db := newTestDB(t, dialect)
+				users := UserModel{DB: db}
+				m := OrganisationModel{DB: db}
+
+				err := users.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+				assert.NilError(t, err)
+
+				id, err := m.Insert(t.Context(), "Acme", "acme", 1)
+				assert.NilError(t, err)
+
+				token, err := m.Invite(t.Context(), id, tt.invitee, 1)
+				assert.NilError(t, err)
+
+				if tt.token != "" {
+					token = tt.token
+				}
+
+				_, err = m.AcceptInvitation(t.Context(), token, 2)
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+
+				role, err := m.MemberRole(t.Context(), id, 2)
+				if tt.wantErr == nil {
+					assert.NilError(t, err)
+					assert.Equal(t, role, OrganisationMember)
+				} else {
+					assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+				}
+			})
+		}
+	})
+}
+
+func TestForOrganisation(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		users := UserModel{DB: db}
+		organisations := OrganisationModel{DB: db}
+		snippets := SnippetModel{DB: db}
+
+		err := users.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+
+		id, err := organisations.Insert(t.Context(), "Acme", "acme", 1)
+		assert.NilError(t, err)
+
+		_, err = snippets.Insert(t.Context(), 1, "Team", "Content", "", 7, VisibilityTeam, id)
+		assert.NilError(t, err)
+		_, err = snippets.Insert(t.Context(), 1, "Private", "Content", "", 7, VisibilityPrivate, id)
+		assert.NilError(t, err)
+		_, err = snippets.Insert(t.Context(), 1, "Elsewhere", "Content", "", 7, VisibilityTeam, 0)
+		assert.NilError(t, err)
+
+		// The author sees their own private snippet, other members do not
+		forAuthor, err := snippets.ForOrganisation(t.Context(), id, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(forAuthor), 2)
+
+		forMember, err := snippets.ForOrganisation(t.Context(), id, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(forMember), 1)
+		assert.Equal(t, forMember[0].Title, "Team")
+
+		// Only public snippets are listed on the home page
+		latest, err := snippets.Latest(t.Context())
+		assert.NilError(t, err)
+		for _, s := range latest {
+			assert.Equal(t, s.Visibility, VisibilityPublic)
+		}
+	})
+}
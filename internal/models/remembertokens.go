@@ -0,0 +1,237 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+type RememberTokenModelInterface interface {
+	New(ctx context.Context, userID int, family string, lifetime time.Duration) (*RememberToken, error)
+	Consume(ctx context.Context, selector, validator string) (*RememberToken, error)
+	Revoke(ctx context.Context, selector string) error
+	DeleteAllForUser(ctx context.Context, userID int) error
+	DeleteExpired(ctx context.Context) (int, error)
+}
+
+// INFO: Selector/validator pattern. The selector is stored in plain text and
+// used to look the token up, the validator is only ever stored as a hash and
+// is compared in constant time. Every token belongs to a family, which is
+// shared by all the tokens a single login has been rotated into.
+type RememberToken struct {
+	Selector  string
+	Validator string
+	UserID    int
+	Family    string
+	Expires   time.Time
+	// Set when the token had already been rotated by another request within
+	// the grace period. It still logs the user in, but must not be rotated
+	// again since that request has handed out the token which replaces it.
+	Rotated bool
+}
+
+// A browser loading several pages or tabs at once sends the same cookie with
+// each of them. Only one of those requests gets to rotate the token, the
+// others present it again right after, which is not theft.
+const rotationGracePeriod = 30 * time.Second
+
+// How long a rotated token is kept to notice it being presented again. After
+// that a copy of it is simply unknown, which still refuses it but no longer
+// revokes the rest of its family.
+const rotatedTokenRetention = 7 * 24 * time.Hour
+
+/* Value to be stored in the users cookie */
+func (t *RememberToken) String() string {
+	return t.Selector + ":" + t.Validator
+}
+
+func ParseRememberToken(value string) (selector, validator string, ok bool) {
+	selector, validator, ok = strings.Cut(value, ":")
+	if !ok || selector == "" || validator == "" {
+		return "", "", false
+	}
+
+	return selector, validator, true
+}
+
+type RememberTokenModel struct {
+	DB *database.DB
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func NewTokenFamily() (string, error) {
+	return randomString(12)
+}
+
+func hashValidator(validator string) []byte {
+	hash := sha256.Sum256([]byte(validator))
+	return hash[:]
+}
+
+// Creates a new token in the given family. The plain text validator is only
+// available on the returned token, it is never read back from the db.
+func (m *RememberTokenModel) New(ctx context.Context, userID int, family string, lifetime time.Duration) (*RememberToken, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	selector, err := randomString(12)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &RememberToken{
+		Selector:  selector,
+		Validator: validator,
+		UserID:    userID,
+		Family:    family,
+		Expires:   time.Now().Add(lifetime).UTC(),
+	}
+
+	stmt := `INSERT INTO remember_tokens (selector, hashed_validator, user_id, family, expires)
+	VALUES (?, ?, ?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, token.Selector, hashValidator(token.Validator), token.UserID, token.Family, token.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Validates a token and marks it as rotated so it can not be used again.
+// Presenting a token which was rotated longer than rotationGracePeriod ago
+// means that somebody else holds a copy of it, so the whole family is revoked.
+func (m *RememberTokenModel) Consume(ctx context.Context, selector, validator string) (*RememberToken, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	var hashedValidator []byte
+	var rotatedAt sql.NullTime
+
+	token := &RememberToken{Selector: selector}
+
+	stmt := "SELECT hashed_validator, user_id, family, rotated_at, expires FROM remember_tokens WHERE selector = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, selector).Scan(&hashedValidator, &token.UserID, &token.Family, &rotatedAt, &token.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(hashedValidator, hashValidator(validator)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	if time.Now().After(token.Expires) {
+		if err := m.deleteFamily(ctx, token.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoRecord
+	}
+
+	if rotatedAt.Valid {
+		if time.Since(rotatedAt.Time) <= rotationGracePeriod {
+			token.Rotated = true
+			return token, nil
+		}
+
+		if err := m.deleteFamily(ctx, token.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+
+	/* INFO: Only one of several concurrent requests with the same token
+	   updates the row, the others look at it again and find it rotated */
+	stmt = "UPDATE remember_tokens SET rotated_at = ? WHERE selector = ? AND rotated_at IS NULL"
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now().UTC(), selector)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return m.Consume(ctx, selector, validator)
+	}
+
+	return token, nil
+}
+
+/* Removes every token in the same family as selector, used when logging out */
+func (m *RememberTokenModel) Revoke(ctx context.Context, selector string) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "DELETE FROM remember_tokens WHERE family = (SELECT family FROM remember_tokens WHERE selector = ?)"
+
+	_, err := m.DB.ExecContext(ctx, stmt, selector)
+	return err
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "DELETE FROM remember_tokens WHERE user_id = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	return err
+}
+
+// Deletes expired tokens and those rotated longer than rotatedTokenRetention
+// ago, returning how many there were.
+func (m *RememberTokenModel) DeleteExpired(ctx context.Context) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	stmt := "DELETE FROM remember_tokens WHERE expires <= ? OR rotated_at <= ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, now, now.Add(-rotatedTokenRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func (m *RememberTokenModel) deleteFamily(ctx context.Context, family string) error {
+	stmt := "DELETE FROM remember_tokens WHERE family = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, family)
+	return err
+}
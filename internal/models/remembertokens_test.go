@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+func TestRememberTokenRotation(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := RememberTokenModel{DB: db}
+
+		family, err := NewTokenFamily()
+		assert.NilError(t, err)
+
+		first, err := m.New(t.Context(), 1, family, time.Hour)
+		assert.NilError(t, err)
+
+		token, err := m.Consume(t.Context(), first.Selector, first.Validator)
+		assert.NilError(t, err)
+		assert.Equal(t, token.UserID, 1)
+		assert.Equal(t, token.Family, family)
+		assert.Equal(t, token.Rotated, false)
+
+		second, err := m.New(t.Context(), token.UserID, token.Family, time.Hour)
+		assert.NilError(t, err)
+
+		// Wrong validator for a known selector
+		_, err = m.Consume(t.Context(), second.Selector, "wrong")
+		assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+		// Presented again straight away, as by a concurrent request, it
+		// still works but must not be rotated again
+		token, err = m.Consume(t.Context(), first.Selector, first.Validator)
+		assert.NilError(t, err)
+		assert.Equal(t, token.Rotated, true)
+
+		_, err = db.Exec("UPDATE remember_tokens SET rotated_at = ? WHERE selector = ?", time.Now().Add(-time.Hour).UTC(), first.Selector)
+		assert.NilError(t, err)
+
+		// Reusing the rotated token once the grace period has passed revokes
+		// the whole family, including the token it was rotated into
+		_, err = m.Consume(t.Context(), first.Selector, first.Validator)
+		assert.Equal(t, errors.Is(err, ErrTokenReused), true)
+
+		_, err = m.Consume(t.Context(), second.Selector, second.Validator)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
+
+func TestRememberTokenExpired(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := RememberTokenModel{DB: db}
+
+		token, err := m.New(t.Context(), 1, "family", -time.Minute)
+		assert.NilError(t, err)
+
+		_, err = m.Consume(t.Context(), token.Selector, token.Validator)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
+
+func TestRememberTokenDeleteExpired(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := RememberTokenModel{DB: db}
+
+		family, err := NewTokenFamily()
+		assert.NilError(t, err)
+
+		expired, err := m.New(t.Context(), 1, family, -time.Minute)
+		assert.NilError(t, err)
+		rotated, err := m.New(t.Context(), 1, family, time.Hour)
+		assert.NilError(t, err)
+		recent, err := m.New(t.Context(), 1, family, time.Hour)
+		assert.NilError(t, err)
+		current, err := m.New(t.Context(), 1, family, time.Hour)
+		assert.NilError(t, err)
+
+		_, err = db.Exec("UPDATE remember_tokens SET rotated_at = ? WHERE selector = ?", time.Now().Add(-rotatedTokenRetention-time.Hour).UTC(), rotated.Selector)
+		assert.NilError(t, err)
+		_, err = db.Exec("UPDATE remember_tokens SET rotated_at = ? WHERE selector = ?", time.Now().Add(-time.Hour).UTC(), recent.Selector)
+		assert.NilError(t, err)
+
+		n, err := m.DeleteExpired(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, n, 2)
+
+		tests := []struct {
+			name    string
+			token   *RememberToken
+			wantErr error
+		}{
+			{name: "Expired", token: expired, wantErr: ErrNoRecord},
+			{name: "Rotated long ago", token: rotated, wantErr: ErrNoRecord},
+			/* Still kept to notice reuse, which revokes the family */
+			{name: "Rotated recently", token: recent, wantErr: ErrTokenReused},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := m.Consume(t.Context(), tt.token.Selector, tt.token.Validator)
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+			})
+		}
+
+		/* The reuse revoked the rest of the family */
+		_, err = m.Consume(t.Context(), current.Selector, current.Validator)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
@@ -1,16 +1,29 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
 )
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Insert(ctx context.Context, userID int, title string, content string, language string, expires int, visibility string, organisationID int) (int, error)
+	Get(ctx context.Context, id int) (*Snippet, error)
+	Latest(ctx context.Context) ([]*Snippet, error)
+	AllForUser(ctx context.Context, userID int) ([]*Snippet, error)
+	ForUser(ctx context.Context, userID int) ([]*Snippet, error)
+	ForOrganisation(ctx context.Context, organisationID, viewerID int) ([]*Snippet, error)
+	Public(ctx context.Context, search string, limit, offset int) ([]*Snippet, error)
+	Browse(ctx context.Context, limit, offset int) ([]*Snippet, error)
+	Update(ctx context.Context, id int, title string, content string, language string, expires time.Time, visibility string, organisationID int) error
+	Delete(ctx context.Context, id int) error
+	TakeExpired(ctx context.Context) ([]*Snippet, error)
 }
 
 type Snippet struct {
@@ -19,40 +32,70 @@ type Snippet struct {
 	Content string
 	Created time.Time
 	Expires time.Time
+	/* Zero for anonymous snippets, eg. when the author deleted their account */
+	UserID int
+	/* Zero unless the snippet was created under an organisation */
+	OrganisationID int
+	Visibility     string
+	/* Free form hint for syntax highlighting, eg. "go", empty if unknown */
+	Language string
 }
 
+const (
+	/* Listed on the home page and the authors profile */
+	VisibilityPublic = "public"
+	/* Anyone with the link can view it, but it is not listed anywhere */
+	VisibilityUnlisted = "unlisted"
+	/* Only members of the snippets organisation can view it */
+	VisibilityTeam = "team"
+	/* Only the author can view it */
+	VisibilityPrivate = "private"
+)
+
+const snippetColumns = "id, title, content, created, expires, user_id, organisation_id, visibility, language"
+
 type SnippetModel struct {
-	DB *sql.DB
+	DB *database.DB
 }
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// userID and organisationID are stored as NULL when zero
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, content string, language string, expires int, visibility string, organisationID int) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	/* INFO: Times come from Go rather than DATE(), which PostgreSQL does not
+	   have. Always UTC, since SQLite compares them as text */
+	now := time.Now().UTC()
 	// Adds number of days to expiration
-	expiration := time.Now().AddDate(0, 0, expires)
+	expiration := now.AddDate(0, 0, expires)
 	// stmt := fmt.Sprintf("INSERT INTO snippets (title, content, created, expires) VALUES (%s, %s, DATE(), %s)",
 	// 	title, content, expiration)
-	stmt := "INSERT INTO snippets (title, content, created, expires) VALUES (?, ?, DATE(), ?)"
-	result, err := m.DB.Exec(stmt, title, content, expiration)
-	if err != nil {
-		return 0, errors.New(fmt.Sprintf("Error DB.Exec: %s", err))
-	}
+	/* INFO: RETURNING instead of LastInsertId(), which the postgres driver
+	   does not support */
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, organisation_id, visibility, language)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`
 
 	/* ID of our newly inserted record */
-	id, err := result.LastInsertId()
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt, title, content, now, expiration, nullInt(userID), nullInt(organisationID), visibility, language).Scan(&id)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("Error DB.QueryRow: %w", err)
 	}
 
-	return int(id), nil
+	return id, nil
 }
 
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
-	stmt := "SELECT * FROM snippets WHERE expires > DATE() AND ID = ?;"
+func (m *SnippetModel) Get(ctx context.Context, id int) (*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
 
-	row := m.DB.QueryRow(stmt, id)
+	stmt := "SELECT " + snippetColumns + " FROM snippets WHERE expires > ? AND ID = ?;"
+
+	row := m.DB.QueryRowContext(ctx, stmt, time.Now().UTC(), id)
 
 	s := &Snippet{}
 
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := scanSnippet(row, s)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -64,11 +107,14 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	return s, nil
 }
 
-// This will get the most recent 10 snippets
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	stmt := "SELECT * FROM snippets WHERE expires > DATE() ORDER BY id DESC LIMIT 10;"
+// This will get the most recent 10 public snippets
+func (m *SnippetModel) Latest(ctx context.Context) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT " + snippetColumns + " FROM snippets WHERE expires > ? AND visibility = 'public' ORDER BY id DESC LIMIT 10;"
 
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.QueryContext(ctx, stmt, time.Now().UTC())
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +128,7 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	/* INFO: The resultset will automatically close itself when iteration completes */
 	for rows.Next() {
 		s := &Snippet{}
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err := scanSnippet(rows, s)
 		if err != nil {
 			return nil, err
 		}
@@ -97,3 +143,183 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 
 	return snippets, nil
 }
+
+/* Every snippet owned by the user, including expired ones */
+func (m *SnippetModel) AllForUser(ctx context.Context, userID int) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT " + snippetColumns + " FROM snippets WHERE user_id = ? ORDER BY id;"
+
+	return m.query(ctx, stmt, userID)
+}
+
+/* The users public snippets which have not expired, most recent first */
+func (m *SnippetModel) ForUser(ctx context.Context, userID int) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT " + snippetColumns + " FROM snippets WHERE expires > ? AND user_id = ? AND visibility = 'public' ORDER BY id DESC;"
+
+	return m.query(ctx, stmt, time.Now().UTC(), userID)
+}
+
+// The organisations snippets which have not expired, as seen by one of its
+// members. Private snippets are only included for their author.
+func (m *SnippetModel) ForOrganisation(ctx context.Context, organisationID, viewerID int) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT " + snippetColumns + ` FROM snippets
+	WHERE expires > ? AND organisation_id = ? AND (visibility != 'private' OR user_id = ?)
+	ORDER BY id DESC;`
+
+	return m.query(ctx, stmt, time.Now().UTC(), organisationID, viewerID)
+}
+
+// Public snippets which have not expired, most recent first. search matches
+// the title or content, an empty search matches everything.
+func (m *SnippetModel) Public(ctx context.Context, search string, limit, offset int) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	/* INFO: LIKE is case sensitive in PostgreSQL but not in SQLite, lower
+	   casing both sides makes it case insensitive in both */
+	pattern := "%" + strings.ToLower(search) + "%"
+
+	stmt := "SELECT " + snippetColumns + ` FROM snippets
+	WHERE expires > ? AND visibility = 'public' AND (LOWER(title) LIKE ? OR LOWER(content) LIKE ?)
+	ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	return m.query(ctx, stmt, time.Now().UTC(), pattern, pattern, limit, offset)
+}
+
+/* Every snippet including expired ones, most recent first */
+func (m *SnippetModel) Browse(ctx context.Context, limit, offset int) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT " + snippetColumns + " FROM snippets ORDER BY id DESC LIMIT ? OFFSET ?;"
+
+	return m.query(ctx, stmt, limit, offset)
+}
+
+/* Replaces every editable field, organisationID is stored as NULL when zero */
+func (m *SnippetModel) Update(ctx context.Context, id int, title string, content string, language string, expires time.Time, visibility string, organisationID int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "UPDATE snippets SET title = ?, content = ?, language = ?, expires = ?, visibility = ?, organisation_id = ? WHERE id = ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, title, content, language, expires, visibility, nullInt(organisationID), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "DELETE FROM snippets WHERE id = ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Snippets which have expired since the last call, each is only ever returned
+// once. They are marked and read back in a single UPDATE ... RETURNING, so
+// that two callers, even on different instances, can not both take the same
+// snippet.
+func (m *SnippetModel) TakeExpired(ctx context.Context) ([]*Snippet, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "UPDATE snippets SET expiry_notified = true WHERE expires <= ? AND expiry_notified = false RETURNING " + snippetColumns + ";"
+
+	snippets, err := m.query(ctx, stmt, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	/* INFO: RETURNING gives the rows in no particular order */
+	slices.SortFunc(snippets, func(a, b *Snippet) int {
+		return a.ID - b.ID
+	})
+
+	return snippets, nil
+}
+
+func (m *SnippetModel) query(ctx context.Context, stmt string, args ...any) ([]*Snippet, error) {
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+		err := scanSnippet(rows, s)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+/* Implemented by both *sql.Row and *sql.Rows */
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSnippet(row scanner, s *Snippet) error {
+	var userID, organisationID sql.NullInt64
+
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &userID, &organisationID, &s.Visibility, &s.Language)
+	if err != nil {
+		return err
+	}
+
+	s.UserID = int(userID.Int64)
+	s.OrganisationID = int(organisationID.Int64)
+
+	return nil
+}
+
+/* Zero is stored as NULL, for optional foreign keys */
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+func TestSnippetUpdate(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := SnippetModel{DB: db}
+
+		// Alice is user 1 in testdata/setup.sql
+		id, err := m.Insert(t.Context(), 1, "Title", "Content", "", 7, VisibilityPublic, 0)
+		assert.NilError(t, err)
+
+		err = m.Update(t.Context(), id, "New title", "New content", "go", time.Now().AddDate(0, 0, 1), VisibilityPrivate, 0)
+		assert.NilError(t, err)
+
+		snippet, err := m.Get(t.Context(), id)
+		assert.NilError(t, err)
+		assert.Equal(t, snippet.Title, "New title")
+		assert.Equal(t, snippet.Content, "New content")
+		assert.Equal(t, snippet.Visibility, VisibilityPrivate)
+		assert.Equal(t, snippet.Language, "go")
+
+		err = m.Update(t.Context(), id+1, "Title", "Content", "", time.Now(), VisibilityPublic, 0)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
+
+func TestSnippetPublic(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := SnippetModel{DB: db}
+
+		for _, visibility := range []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate, VisibilityPublic} {
+			_, err := m.Insert(t.Context(), 1, "Title", "Content", "", 7, visibility, 0)
+			assert.NilError(t, err)
+		}
+
+		firstPage, err := m.Public(t.Context(), "", 1, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(firstPage), 1)
+
+		all, err := m.Public(t.Context(), "", 10, 0)
+		assert.NilError(t, err)
+		for _, s := range all {
+			assert.Equal(t, s.Visibility, VisibilityPublic)
+		}
+
+		// Most recent first, so the second page starts after firstPage
+		secondPage, err := m.Public(t.Context(), "", 1, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(secondPage), 1)
+		assert.Equal(t, secondPage[0].ID < firstPage[0].ID, true)
+
+		_, err = m.Insert(t.Context(), 1, "Needle", "Content", "", 7, VisibilityPublic, 0)
+		assert.NilError(t, err)
+
+		found, err := m.Public(t.Context(), "needle", 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(found), 1)
+		assert.Equal(t, found[0].Title, "Needle")
+	})
+}
+
+func TestSnippetTakeExpired(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := SnippetModel{DB: db}
+
+		expired, err := m.Insert(t.Context(), 1, "Expired", "Content", "", -1, VisibilityPublic, 0)
+		assert.NilError(t, err)
+
+		_, err = m.Insert(t.Context(), 1, "Current", "Content", "", 7, VisibilityPublic, 0)
+		assert.NilError(t, err)
+
+		snippets, err := m.TakeExpired(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, expired)
+
+		/* Each snippet is only taken once */
+		snippets, err = m.TakeExpired(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+	})
+}
+
+/* Callers racing each other take every expired snippet exactly once between them */
+func TestSnippetTakeExpiredConcurrent(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := SnippetModel{DB: db}
+
+		for range 20 {
+			_, err := m.Insert(t.Context(), 1, "Expired", "Content", "", -1, VisibilityPublic, 0)
+			assert.NilError(t, err)
+		}
+
+		var wg sync.WaitGroup
+		taken := make([]int, 4)
+		errs := make([]error, 4)
+
+		for i := range taken {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				snippets, err := m.TakeExpired(t.Context())
+				taken[i], errs[i] = len(snippets), err
+			}()
+		}
+		wg.Wait()
+
+		total := 0
+		for i := range taken {
+			assert.NilError(t, errs[i])
+			total += taken[i]
+		}
+		assert.Equal(t, total, 20)
+	})
+}
+
+func TestSnippetContext(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := SnippetModel{DB: db}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := m.Get(ctx, 1)
+		assert.Equal(t, errors.Is(err, context.Canceled), true)
+
+		_, err = m.Insert(ctx, 1, "Title", "Content", "", 7, VisibilityPublic, 0)
+		assert.Equal(t, errors.Is(err, context.Canceled), true)
+
+		/* The query timeout applies on top of the callers context */
+		db.QueryTimeout = time.Nanosecond
+		_, err = m.Latest(t.Context())
+		assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+	})
+}
@@ -1,23 +1,6 @@
-CREATE TABLE snippets (
-    id INTEGER NOT NULL PRIMARY KEY,
-    title VARCHAR(100) NOT NULL,
-    content TEXT NOT NULL,
-    created DATETIME NOT NULL,
-    expires DATETIME NOT NULL
-);
-
-CREATE INDEX idx_snippets_created ON snippets(created);
-
-CREATE TABLE users (
-    id INTEGER NOT NULL PRIMARY KEY,
-    name VARCHAR(255) NOT NULL,
-    email VARCHAR(255) NOT NULL UNIQUE,
-    hashed_password CHAR(60) NOT NULL,
-    created DATETIME NOT NULL
-);
-
-INSERT INTO users (name, email, hashed_password, created) VALUES (
+INSERT INTO users (name, username, email, hashed_password, created) VALUES (
     'Alice Jones',
+    'alice',
     'alice@example.com',
     '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG',
     '2022-01-01 10:00:00'
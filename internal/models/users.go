@@ -1,50 +1,134 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	"github.com/mattn/go-sqlite3" /* INFO: Uses CGO */
-	"golang.org/x/crypto/bcrypt"
+	"github.com/mohafarman/snippetbox/internal/database"
+	"github.com/mohafarman/snippetbox/internal/passwords"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Looked up on every use rather than once, so that the tracer provider can be
+// swapped out, eg. by the tests
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/mohafarman/snippetbox/internal/models")
+}
+
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
-	Get(id int) (*User, error)
-	CompareAndUpdatePassword(id int, currentPassword, newPassword string) (bool, error)
+	Insert(ctx context.Context, name, username, email, password string) error
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (*User, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	List(ctx context.Context, search string, limit, offset int) ([]*User, error)
+	SetRole(ctx context.Context, id int, role string) error
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	RequirePasswordReset(ctx context.Context, id int) error
+	CompareAndUpdatePassword(ctx context.Context, id int, currentPassword, newPassword string) (bool, error)
+	Delete(ctx context.Context, id int, password string, keepSnippets bool) error
+	UpdateName(ctx context.Context, id int, name string) error
+	RequestEmailChange(ctx context.Context, id int, newEmail string) (string, error)
+	ConfirmEmailChange(ctx context.Context, token string) (int, error)
 }
 
 type User struct {
 	ID             int
 	Name           string
+	Username       string
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Role           string
+	/* Disabled users can not log in and are logged out everywhere */
+	Disabled bool
+	/* Set by an admin, the user has to change their password after logging in */
+	PasswordResetRequired bool
+}
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+/* Roles are ordered, an admin can do everything a moderator can */
+func (u *User) HasRole(role string) bool {
+	return roleRanks[u.Role] >= roleRanks[role]
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB *database.DB
+	/* Uses passwords.DefaultParams when nil */
+	Hasher *passwords.Hasher
+}
+
+func (m *UserModel) hasher() *passwords.Hasher {
+	if m.Hasher == nil {
+		return passwords.New(passwords.DefaultParams)
+	}
+
+	return m.Hasher
+}
+
+// Password hashing is slow on purpose, so it gets spans of its own to tell
+// it apart from the queries around it
+func (m *UserModel) hashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer().Start(ctx, "passwords.Hash")
+	defer span.End()
+
+	return m.hasher().Hash(password)
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (m *UserModel) verifyPassword(ctx context.Context, password, hash string) (bool, bool, error) {
+	algorithm := "argon2id"
+	if strings.HasPrefix(hash, "$2") {
+		algorithm = "bcrypt"
+	}
+
+	_, span := tracer().Start(ctx, "passwords.Verify", trace.WithAttributes(attribute.String("password.algorithm", algorithm)))
+	defer span.End()
+
+	return m.hasher().Verify(password, hash)
+}
+
+func (m *UserModel) Insert(ctx context.Context, name, username, email, password string) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := m.hashPassword(ctx, password)
 	if err != nil {
 		return err
 	}
 
-	stmt := "INSERT INTO users (name, email, hashed_password, created) VALUES (?, ?, ?, DATE())"
+	stmt := "INSERT INTO users (name, username, email, hashed_password, created) VALUES (?, ?, ?, ?, ?)"
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	_, err = m.DB.ExecContext(ctx, stmt, name, username, email, hashedPassword, time.Now().UTC())
 	if err != nil {
-		/* Handle duplicate email adress */
-		var sqlite3Error sqlite3.Error
-		if errors.As(err, &sqlite3Error) {
-			if errors.Is(sqlite3Error.Code, sqlite3.ErrConstraint) {
-				return ErrDuplicateEmail
+		/* Handle duplicate username and email adress */
+		if constraint, ok := database.UniqueViolation(err); ok {
+			if strings.Contains(constraint, "username") {
+				return ErrDuplicateUsername
 			}
+			return ErrDuplicateEmail
 		}
 		/* Simply return err for all other errors */
 		return err
@@ -53,13 +137,17 @@ func (m *UserModel) Insert(name, email, password string) error {
 }
 
 /* Return user ID */
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
 	var id int
-	var hashedPassword []byte
+	var hashedPassword string
+	var disabled bool
 
-	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
+	stmt := "SELECT id, hashed_password, disabled FROM users WHERE email = ?"
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		/* Error thrown when there are no rows:
 		   "sql: no rows in result set" */
@@ -70,12 +158,25 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		}
 	}
 
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	match, needsRehash, err := m.verifyPassword(ctx, password, hashedPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
-		} else {
-			return 0, nil
+		return 0, err
+	}
+	if !match {
+		return 0, ErrInvalidCredentials
+	}
+
+	/* Only reported for the right password, so it does not reveal anything */
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
+	/* INFO: The plain text password is only available here, so legacy bcrypt
+	   and out of date argon2id hashes are upgraded on a successful login */
+	if needsRehash {
+		err = m.setPassword(ctx, id, password)
+		if err != nil {
+			return 0, err
 		}
 	}
 
@@ -83,24 +184,50 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 }
 
 /* Checks if user already exists in the table 'users' */
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
 	var exists bool
 
-	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
+	/* Disabled users are treated as if they no longer exist */
+	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ? AND NOT disabled)"
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 
 	return exists, err
 }
 
-func (m *UserModel) Get(id int) (*User, error) {
+func (m *UserModel) Get(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.getBy(ctx, "id", id)
+}
+
+func (m *UserModel) GetByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.getBy(ctx, "username", username)
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.getBy(ctx, "email", email)
+}
+
+/* column is never user input, it is one of the unique columns above */
+func (m *UserModel) getBy(ctx context.Context, column string, value any) (*User, error) {
 	user := &User{}
 
-	stmt := "SELECT id, name, email, created FROM users WHERE id = ?;"
+	stmt := "SELECT " + userColumns + " FROM users WHERE " + column + " = ?;"
 
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRowContext(ctx, stmt, value)
 
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	err := scanUser(row, user)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -112,34 +239,381 @@ func (m *UserModel) Get(id int) (*User, error) {
 	return user, nil
 }
 
-func (m *UserModel) CompareAndUpdatePassword(id int, currentPassword, newPassword string) (bool, error) {
-	var hashedDBpassword string
+const userColumns = "id, name, username, email, created, role, disabled, password_reset_required"
+
+func scanUser(row scanner, user *User) error {
+	return row.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.Created,
+		&user.Role, &user.Disabled, &user.PasswordResetRequired)
+}
+
+func (m *UserModel) CompareAndUpdatePassword(ctx context.Context, id int, currentPassword, newPassword string) (bool, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
 
-	stmt := "SELECT hashed_password FROM users WHERE id = ?;"
+	err := m.checkPassword(ctx, id, currentPassword)
+	if err != nil {
+		return false, err
+	}
 
-	row := m.DB.QueryRow(stmt, id)
-	err := row.Scan(&hashedDBpassword)
+	err = m.setPassword(ctx, id, newPassword)
 	if err != nil {
 		return false, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedDBpassword), []byte(currentPassword))
+	return true, nil
+}
+
+/* Returns ErrInvalidCredentials if password is not the users password */
+func (m *UserModel) checkPassword(ctx context.Context, id int, password string) error {
+	var hashedPassword string
+
+	stmt := "SELECT hashed_password FROM users WHERE id = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&hashedPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return false, ErrInvalidCredentials
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
 		}
 	}
 
-	hashedNewPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	match, _, err := m.verifyPassword(ctx, password, hashedPassword)
 	if err != nil {
-		return false, err
+		return err
+	}
+	if !match {
+		return ErrInvalidCredentials
 	}
 
-	stmt = "UPDATE users SET hashed_password = ?"
-	m.DB.Exec(stmt, hashedNewPassword)
+	return nil
+}
+
+func (m *UserModel) setPassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := m.hashPassword(ctx, password)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	return true, nil
+	stmt := "UPDATE users SET hashed_password = ?, password_reset_required = false WHERE id = ?"
+
+	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	return err
+}
+
+// Deletes the user after checking their password. Their personal snippets are
+// either deleted along with them or kept as anonymous snippets, while snippets
+// they wrote inside an organisation always stay with it. Organisations the
+// user was the only owner of are handed to their longest-standing member, and
+// ones without any other member are deleted along with their snippets.
+func (m *UserModel) Delete(ctx context.Context, id int, password string, keepSnippets bool) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	err := m.checkPassword(ctx, id, password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	/* INFO: Rollback is a no-op once the transaction has been committed */
+	defer tx.Rollback()
+
+	err = transferOrganisations(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	var stmt string
+	if keepSnippets {
+		stmt = "UPDATE snippets SET user_id = NULL WHERE user_id = ?"
+	} else {
+		stmt = "DELETE FROM snippets WHERE user_id = ? AND organisation_id IS NULL"
+	}
+
+	stmts := []string{
+		stmt,
+		/* Whatever is left belongs to an organisation */
+		"UPDATE snippets SET user_id = NULL WHERE user_id = ?",
+		"DELETE FROM remember_tokens WHERE user_id = ?",
+		"DELETE FROM exports WHERE user_id = ?",
+		"DELETE FROM email_changes WHERE user_id = ?",
+		"DELETE FROM organisation_members WHERE user_id = ?",
+		"DELETE FROM api_tokens WHERE user_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)",
+		"DELETE FROM webhooks WHERE user_id = ?",
+		"DELETE FROM organisation_invitations WHERE invited_by = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+
+	for _, stmt := range stmts {
+		_, err = tx.ExecContext(ctx, stmt, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Makes sure no organisation is left without an owner once the user is gone.
+// The longest-standing member takes over where the user is the only owner, and
+// organisations the user is the last member of are deleted.
+func transferOrganisations(ctx context.Context, tx *database.Tx, userID int) error {
+	stmt := `SELECT organisation_id FROM organisation_members m
+	WHERE user_id = ? AND role = ? AND NOT EXISTS (
+		SELECT 1 FROM organisation_members o
+		WHERE o.organisation_id = m.organisation_id AND o.role = ? AND o.user_id <> m.user_id
+	)`
+
+	rows, err := tx.QueryContext(ctx, stmt, userID, OrganisationOwner, OrganisationOwner)
+	if err != nil {
+		return err
+	}
+
+	var organisationIDs []int
+	for rows.Next() {
+		var organisationID int
+		err = rows.Scan(&organisationID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		organisationIDs = append(organisationIDs, organisationID)
+	}
+	/* INFO: The rows have to be closed before the transaction can be used again */
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, organisationID := range organisationIDs {
+		stmt = `UPDATE organisation_members SET role = ?
+		WHERE organisation_id = ? AND user_id = (
+			SELECT user_id FROM organisation_members
+			WHERE organisation_id = ? AND user_id <> ?
+			ORDER BY joined, user_id LIMIT 1
+		)`
+
+		result, err := tx.ExecContext(ctx, stmt, OrganisationOwner, organisationID, organisationID, userID)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		/* Nobody else is left to take the organisation over */
+		stmts := []string{
+			"DELETE FROM snippets WHERE organisation_id = ?",
+			"DELETE FROM organisation_invitations WHERE organisation_id = ?",
+			"DELETE FROM organisation_members WHERE organisation_id = ?",
+			"DELETE FROM organisations WHERE id = ?",
+		}
+
+		for _, stmt := range stmts {
+			_, err = tx.ExecContext(ctx, stmt, organisationID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "UPDATE users SET name = ? WHERE id = ?"
+
+	_, err := m.DB.ExecContext(ctx, stmt, name, id)
+	return err
+}
+
+// Stores a pending change of the users email address and returns the token
+// which confirms it. The address is only changed by ConfirmEmailChange, so a
+// typo can not lock the user out of their account.
+func (m *UserModel) RequestEmailChange(ctx context.Context, id int, newEmail string) (string, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM users WHERE email = ?)"
+
+	err := m.DB.QueryRowContext(ctx, stmt, newEmail).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return "", ErrDuplicateEmail
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	/* Only the most recent request can be confirmed */
+	stmt = "DELETE FROM email_changes WHERE user_id = ?"
+	_, err = tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return "", err
+	}
+
+	stmt = "INSERT INTO email_changes (user_id, new_email, hashed_token, expires) VALUES (?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, stmt, id, newEmail, hashValidator(token), time.Now().Add(24*time.Hour).UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+/* Returns the ID of the user whose email address was changed */
+func (m *UserModel) ConfirmEmailChange(ctx context.Context, token string) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	var id int
+	var newEmail string
+	var expires time.Time
+
+	stmt := "SELECT user_id, new_email, expires FROM email_changes WHERE hashed_token = ?"
+
+	err := m.DB.QueryRowContext(ctx, stmt, hashValidator(token)).Scan(&id, &newEmail, &expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	if time.Now().After(expires) {
+		return 0, ErrNoRecord
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt = "UPDATE users SET email = ? WHERE id = ?"
+	_, err = tx.ExecContext(ctx, stmt, newEmail, id)
+	if err != nil {
+		/* Somebody signed up with the address since the change was requested */
+		if _, ok := database.UniqueViolation(err); ok {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	stmt = "DELETE FROM email_changes WHERE user_id = ?"
+	_, err = tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Users matching search in their name, username or email address, newest
+// first. An empty search matches everybody.
+func (m *UserModel) List(ctx context.Context, search string, limit, offset int) ([]*User, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	/* Lower cased like in SnippetModel.Public */
+	pattern := "%" + strings.ToLower(search) + "%"
+
+	stmt := "SELECT " + userColumns + ` FROM users
+	WHERE LOWER(name) LIKE ? OR LOWER(username) LIKE ? OR LOWER(email) LIKE ?
+	ORDER BY id DESC LIMIT ? OFFSET ?;`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pattern, pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user := &User{}
+		err := scanUser(rows, user)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (m *UserModel) SetRole(ctx context.Context, id int, role string) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	if !ValidRole(role) {
+		return fmt.Errorf("models: invalid role %q", role)
+	}
+
+	return m.update(ctx, id, "UPDATE users SET role = ? WHERE id = ?", role, id)
+}
+
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.update(ctx, id, "UPDATE users SET disabled = ? WHERE id = ?", disabled, id)
+}
+
+func (m *UserModel) RequirePasswordReset(ctx context.Context, id int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.update(ctx, id, "UPDATE users SET password_reset_required = true WHERE id = ?", id)
+}
+
+/* Runs an UPDATE of a single user, ErrNoRecord if there is no such user */
+func (m *UserModel) update(ctx context.Context, id int, stmt string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
 }
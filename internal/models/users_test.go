@@ -1,44 +1,355 @@
 package models
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestExists(t *testing.T) {
-	tests := []struct {
-		name   string
-		userId int
-		want   bool
-	}{
-		{
-			name:   "User exists",
-			userId: 1,
-			want:   true,
-		},
-		{
-			name:   "Zero ID",
-			userId: 0,
-			want:   false,
-		},
-		{
-			name:   "User does not exist",
-			userId: 2,
-			want:   false,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := newTestDB(t)
-			m := UserModel{db}
-
-			exists, err := m.Exists(tt.userId)
-
-			assert.NilError(t, err)
-			assert.Equal(t, exists, tt.want)
-		})
-	}
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		tests := []struct {
+			name   string
+			userId int
+			want   bool
+		}{
+			{
+				name:   "User exists",
+				userId: 1,
+				want:   true,
+			},
+			{
+				name:   "Zero ID",
+				userId: 0,
+				want:   false,
+			},
+			{
+				name:   "User does not exist",
+				userId: 2,
+				want:   false,
+			},
+		}
 
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				db := newTestDB(t, dialect)
+				m := UserModel{DB: db}
+
+				exists, err := m.Exists(t.Context(), tt.userId)
+
+				assert.NilError(t, err)
+				assert.Equal(t, exists, tt.want)
+			})
+		}
+
+	})
+}
+
+func TestDelete(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		tests := []struct {
+			name         string
+			password     string
+			keepSnippets bool
+			wantErr      error
+			wantSnippet  bool
+		}{
+			{
+				name:         "Keep snippets",
+				password:     "pa$$word",
+				keepSnippets: true,
+				wantSnippet:  true,
+			},
+			{
+				name:         "Delete snippets",
+				password:     "pa$$word",
+				keepSnippets: false,
+				wantSnippet:  false,
+			},
+			{
+				name:        "Wrong password",
+				password:    "wrong password",
+				wantErr:     ErrInvalidCredentials,
+				wantSnippet: true,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				db := newTestDB(t, dialect)
+				users := UserModel{DB: db}
+				snippets := SnippetModel{DB: db}
+
+				err := users.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+				assert.NilError(t, err)
+
+				// Alice is user 1 in testdata/setup.sql
+				userID := 2
+
+				id, err := snippets.Insert(t.Context(), userID, "Title", "Content", "", 7, VisibilityPublic, 0)
+				assert.NilError(t, err)
+
+				err = users.Delete(t.Context(), userID, tt.password, tt.keepSnippets)
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+
+				snippet, err := snippets.Get(t.Context(), id)
+				assert.Equal(t, err == nil, tt.wantSnippet)
+				if tt.wantSnippet && tt.wantErr == nil {
+					assert.Equal(t, snippet.UserID, 0)
+				}
+
+				exists, err := users.Exists(t.Context(), userID)
+				assert.NilError(t, err)
+				assert.Equal(t, exists, tt.wantErr != nil)
+			})
+		}
+	})
+}
+
+func TestDeleteOrganisations(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		tests := []struct {
+			name           string
+			aliceJoins     bool
+			wantAliceRole  string
+			wantOrgSnippet bool
+			wantOrgDeleted bool
+		}{
+			{
+				name:           "Member takes over",
+				aliceJoins:     true,
+				wantAliceRole:  OrganisationOwner,
+				wantOrgSnippet: true,
+			},
+			{
+				name:           "Last member",
+				aliceJoins:     false,
+				wantOrgSnippet: false,
+				wantOrgDeleted: true,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				db := newTestDB(t, dialect)
+				users := UserModel{DB: db}
+				snippets := SnippetModel{DB: db}
+				organisations := OrganisationModel{DB: db}
+
+				err := users.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+				assert.NilError(t, err)
+
+				// Alice is user 1 in testdata/setup.sql
+				userID := 2
+
+				organisationID, err := organisations.Insert(t.Context(), "Acme", "acme", userID)
+				assert.NilError(t, err)
+
+				if tt.aliceJoins {
+					token, err := organisations.Invite(t.Context(), organisationID, "alice@example.com", userID)
+					assert.NilError(t, err)
+					_, err = organisations.AcceptInvitation(t.Context(), token, 1)
+					assert.NilError(t, err)
+				}
+
+				personalID, err := snippets.Insert(t.Context(), userID, "Personal", "Content", "", 7, VisibilityPublic, 0)
+				assert.NilError(t, err)
+				teamID, err := snippets.Insert(t.Context(), userID, "Team", "Content", "", 7, VisibilityTeam, organisationID)
+				assert.NilError(t, err)
+
+				// Even when asked to delete their snippets, the team ones stay
+				err = users.Delete(t.Context(), userID, "pa$$word", false)
+				assert.NilError(t, err)
+
+				_, err = snippets.Get(t.Context(), personalID)
+				assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+				snippet, err := snippets.Get(t.Context(), teamID)
+				assert.Equal(t, err == nil, tt.wantOrgSnippet)
+				if tt.wantOrgSnippet {
+					assert.Equal(t, snippet.UserID, 0)
+					assert.Equal(t, snippet.OrganisationID, organisationID)
+				}
+
+				_, err = organisations.GetBySlug(t.Context(), "acme")
+				assert.Equal(t, errors.Is(err, ErrNoRecord), tt.wantOrgDeleted)
+
+				if tt.aliceJoins {
+					role, err := organisations.MemberRole(t.Context(), organisationID, 1)
+					assert.NilError(t, err)
+					assert.Equal(t, role, tt.wantAliceRole)
+				}
+			})
+		}
+	})
+}
+
+func TestEmailChange(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := UserModel{DB: db}
+
+		err := m.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+
+		_, err = m.RequestEmailChange(t.Context(), 1, "bob@example.com")
+		assert.Equal(t, errors.Is(err, ErrDuplicateEmail), true)
+
+		token, err := m.RequestEmailChange(t.Context(), 1, "alice@example.org")
+		assert.NilError(t, err)
+
+		// The address is only changed once confirmed
+		user, err := m.Get(t.Context(), 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Email, "alice@example.com")
+
+		_, err = m.ConfirmEmailChange(t.Context(), "wrong token")
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+		id, err := m.ConfirmEmailChange(t.Context(), token)
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+
+		user, err = m.Get(t.Context(), 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.Email, "alice@example.org")
+
+		// Tokens can only be used once
+		_, err = m.ConfirmEmailChange(t.Context(), token)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
+
+func TestInsertDuplicates(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := UserModel{DB: db}
+
+		err := m.Insert(t.Context(), "Alice Smith", "alice", "alice@example.org", "pa$$word")
+		assert.Equal(t, errors.Is(err, ErrDuplicateUsername), true)
+
+		err = m.Insert(t.Context(), "Alice Smith", "alice2", "alice@example.com", "pa$$word")
+		assert.Equal(t, errors.Is(err, ErrDuplicateEmail), true)
+
+		user, err := m.GetByUsername(t.Context(), "alice")
+		assert.NilError(t, err)
+		assert.Equal(t, user.Email, "alice@example.com")
+	})
+}
+
+func TestAuthenticateRehash(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := UserModel{DB: db}
+
+		legacy, err := bcrypt.GenerateFromPassword([]byte("pa$$word"), bcrypt.MinCost)
+		assert.NilError(t, err)
+
+		_, err = db.Exec("UPDATE users SET hashed_password = ? WHERE id = 1", string(legacy))
+		assert.NilError(t, err)
+
+		_, err = m.Authenticate(t.Context(), "alice@example.com", "wrong")
+		assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+		id, err := m.Authenticate(t.Context(), "alice@example.com", "pa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+
+		var hash string
+		err = db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&hash)
+		assert.NilError(t, err)
+		assert.StringContains(t, hash, "$argon2id$")
+
+		// Still works against the new hash
+		id, err = m.Authenticate(t.Context(), "alice@example.com", "pa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, id, 1)
+	})
+}
+
+func TestCompareAndUpdatePassword(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := UserModel{DB: db}
+
+		err := m.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+
+		_, err = m.CompareAndUpdatePassword(t.Context(), 2, "wrong", "new pa$$word")
+		assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+		ok, err := m.CompareAndUpdatePassword(t.Context(), 2, "pa$$word", "new pa$$word")
+		assert.NilError(t, err)
+		assert.Equal(t, ok, true)
+
+		_, err = m.Authenticate(t.Context(), "bob@example.com", "new pa$$word")
+		assert.NilError(t, err)
+
+		// Only Bob's password was changed
+		var alice string
+		err = db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&alice)
+		assert.NilError(t, err)
+		assert.StringContains(t, alice, "$2a$12$")
+	})
+}
+
+func TestDisabledUser(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := UserModel{DB: db}
+
+		err := m.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+
+		err = m.SetDisabled(t.Context(), 2, true)
+		assert.NilError(t, err)
+
+		_, err = m.Authenticate(t.Context(), "bob@example.com", "pa$$word")
+		assert.Equal(t, errors.Is(err, ErrAccountDisabled), true)
+
+		// Wrong passwords do not reveal that the account is disabled
+		_, err = m.Authenticate(t.Context(), "bob@example.com", "wrong")
+		assert.Equal(t, errors.Is(err, ErrInvalidCredentials), true)
+
+		exists, err := m.Exists(t.Context(), 2)
+		assert.NilError(t, err)
+		assert.Equal(t, exists, false)
+
+		err = m.SetDisabled(t.Context(), 99, true)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	})
+}
+
+func TestListAndRoles(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := UserModel{DB: db}
+
+		err := m.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+
+		users, err := m.List(t.Context(), "", 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 2)
+		assert.Equal(t, users[0].Username, "bob")
+		assert.Equal(t, users[0].Role, RoleUser)
+
+		users, err = m.List(t.Context(), "alice", 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 1)
+
+		err = m.SetRole(t.Context(), 1, RoleAdmin)
+		assert.NilError(t, err)
+
+		err = m.SetRole(t.Context(), 1, "superuser")
+		assert.Equal(t, err != nil, true)
+
+		user, err := m.Get(t.Context(), 1)
+		assert.NilError(t, err)
+		assert.Equal(t, user.HasRole(RoleModerator), true)
+		assert.Equal(t, users[0].HasRole(RoleModerator), false)
+	})
 }
@@ -0,0 +1,428 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+const (
+	EventSnippetCreated = "snippet.created"
+	EventSnippetUpdated = "snippet.updated"
+	EventSnippetDeleted = "snippet.deleted"
+	EventSnippetExpired = "snippet.expired"
+)
+
+/* Every event a webhook can subscribe to, in the order they are shown */
+var WebhookEvents = []string{EventSnippetCreated, EventSnippetUpdated, EventSnippetDeleted, EventSnippetExpired}
+
+const (
+	DeliveryPending = "pending"
+	/* Claimed by a worker, see Claim() */
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+/* How long delivered and failed deliveries stay in the log, see Prune() */
+const DeliveryRetention = 30 * 24 * time.Hour
+
+/* Prefix of every signing secret, like API tokens */
+const webhookSecretPrefix = "whsec_"
+
+type WebhookModelInterface interface {
+	Insert(ctx context.Context, userID int, url string, events []string, allSnippets bool) (*Webhook, error)
+	Get(ctx context.Context, id, userID int) (*Webhook, error)
+	ForUser(ctx context.Context, userID int) ([]*Webhook, error)
+	Delete(ctx context.Context, id, userID int) error
+	Subscribed(ctx context.Context, event string, ownerID int) ([]*Webhook, error)
+	Enqueue(ctx context.Context, webhookID int, event string, payload []byte) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	Delivered(ctx context.Context, id, responseStatus int) error
+	Retry(ctx context.Context, id, responseStatus int, lastError string, next time.Time) error
+	Fail(ctx context.Context, id, responseStatus int, lastError string) error
+	Deliveries(ctx context.Context, webhookID, limit int) ([]*WebhookDelivery, error)
+	Prune(ctx context.Context) (int, error)
+}
+
+type Webhook struct {
+	ID     int
+	UserID int
+	URL    string
+	/* Key for the HMAC signature of each delivery, shown to the owner */
+	Secret string
+	Events []string
+	/* Receive events for every snippet instead of only the owners, admins only */
+	AllSnippets bool
+	Created     time.Time
+}
+
+func (w *Webhook) HasEvent(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+func ValidEvent(event string) bool {
+	return slices.Contains(WebhookEvents, event)
+}
+
+// A single event waiting in, or already through, the outbox. Deliveries are
+// only ever written by the request which caused the event and read by the
+// background worker, which is what makes them survive a restart.
+type WebhookDelivery struct {
+	ID        int
+	WebhookID int
+	Event     string
+	Payload   []byte
+	Status    string
+	Attempts  int
+	/* When the worker should next try, meaningless once no longer pending */
+	NextAttempt time.Time
+	/* Zero if there has not been an attempt yet */
+	LastAttempt time.Time
+	/* Zero if the last attempt got no response at all */
+	ResponseStatus int
+	LastError      string
+	Created        time.Time
+	/* Copied from the webhook, only loaded by Claim */
+	URL    string
+	Secret string
+}
+
+type WebhookModel struct {
+	DB *database.DB
+}
+
+func (m *WebhookModel) Insert(ctx context.Context, userID int, url string, events []string, allSnippets bool) (*Webhook, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	secret, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Webhook{
+		UserID:      userID,
+		URL:         url,
+		Secret:      webhookSecretPrefix + secret,
+		Events:      events,
+		AllSnippets: allSnippets,
+		Created:     time.Now().UTC(),
+	}
+
+	stmt := `INSERT INTO webhooks (user_id, url, secret, events, all_snippets, created)
+	VALUES (?, ?, ?, ?, ?, ?) RETURNING id`
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID, url, w.Secret, strings.Join(events, " "), allSnippets, w.Created).Scan(&w.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+/* Only returns the webhook if it belongs to userID */
+func (m *WebhookModel) Get(ctx context.Context, id, userID int) (*Webhook, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT id, user_id, url, secret, events, all_snippets, created FROM webhooks WHERE id = ? AND user_id = ?"
+
+	w, err := scanWebhook(m.DB.QueryRowContext(ctx, stmt, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func (m *WebhookModel) ForUser(ctx context.Context, userID int) ([]*Webhook, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "SELECT id, user_id, url, secret, events, all_snippets, created FROM webhooks WHERE user_id = ? ORDER BY id"
+
+	return m.query(ctx, stmt, userID)
+}
+
+/* Deletes the webhook along with its delivery log, ErrNoRecord if it belongs to somebody else */
+func (m *WebhookModel) Delete(ctx context.Context, id, userID int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The webhooks which want to hear about event on a snippet owned by ownerID:
+// the owners own webhooks and those of admins which receive every snippet.
+// ownerID is zero for anonymous snippets. Webhooks of disabled users, and
+// site wide webhooks of users who are no longer admins, are left out.
+func (m *WebhookModel) Subscribed(ctx context.Context, event string, ownerID int) ([]*Webhook, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT w.id, w.user_id, w.url, w.secret, w.events, w.all_snippets, w.created FROM webhooks w
+	INNER JOIN users u ON u.id = w.user_id
+	WHERE u.disabled = false AND (w.user_id = ? OR (w.all_snippets = true AND u.role = ?))
+	ORDER BY w.id`
+
+	webhooks, err := m.query(ctx, stmt, ownerID, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	/* INFO: Events are stored space separated like token scopes, so they are
+	   filtered here rather than in SQL */
+	subscribed := []*Webhook{}
+	for _, w := range webhooks {
+		if w.HasEvent(event) {
+			subscribed = append(subscribed, w)
+		}
+	}
+
+	return subscribed, nil
+}
+
+/* Adds a delivery to the outbox, due straight away */
+func (m *WebhookModel) Enqueue(ctx context.Context, webhookID int, event string, payload []byte) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt, created)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, webhookID, event, payload, DeliveryPending, now, now)
+	return err
+}
+
+// Takes up to limit deliveries whose next attempt is due, oldest first, and
+// marks them as being sent for lease. Other workers, eg. of another instance,
+// skip them until then. A delivery which is still sending once its lease is
+// up, because its worker died half way, is taken again.
+func (m *WebhookModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	/* INFO: Checking status and next_attempt again outside of the sub query
+	   is what stops two workers from taking the same delivery. A second
+	   UPDATE waits for the first and then no longer matches the rows it took */
+	stmt := `UPDATE webhook_deliveries SET status = ?, next_attempt = ?
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status IN (?, ?) AND next_attempt <= ?
+		ORDER BY next_attempt, id LIMIT ?
+	) AND status IN (?, ?) AND next_attempt <= ?
+	RETURNING id, webhook_id, event, payload, status, attempts, next_attempt,
+	last_attempt, response_status, last_error, created,
+	(SELECT url FROM webhooks w WHERE w.id = webhook_deliveries.webhook_id),
+	(SELECT secret FROM webhooks w WHERE w.id = webhook_deliveries.webhook_id)`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, DeliverySending, now.Add(lease),
+		DeliveryPending, DeliverySending, now, limit,
+		DeliveryPending, DeliverySending, now)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		var lastAttempt sql.NullTime
+		var responseStatus sql.NullInt64
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttempt,
+			&lastAttempt, &responseStatus, &d.LastError, &d.Created, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		d.LastAttempt = lastAttempt.Time
+		d.ResponseStatus = int(responseStatus.Int64)
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	/* RETURNING gives no guarantee about the order of the rows */
+	slices.SortFunc(deliveries, func(a, b *WebhookDelivery) int {
+		return a.ID - b.ID
+	})
+
+	return deliveries, nil
+}
+
+func (m *WebhookModel) Delivered(ctx context.Context, id, responseStatus int) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.attempt(ctx, id, DeliveryDelivered, responseStatus, "", time.Now())
+}
+
+/* Records a failed attempt, the delivery stays pending until next */
+func (m *WebhookModel) Retry(ctx context.Context, id, responseStatus int, lastError string, next time.Time) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.attempt(ctx, id, DeliveryPending, responseStatus, lastError, next)
+}
+
+/* Records a failed attempt, the delivery is given up on */
+func (m *WebhookModel) Fail(ctx context.Context, id, responseStatus int, lastError string) error {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	return m.attempt(ctx, id, DeliveryFailed, responseStatus, lastError, time.Now())
+}
+
+/* The delivery log of a webhook, most recent first */
+func (m *WebhookModel) Deliveries(ctx context.Context, webhookID, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, webhook_id, event, payload, status, attempts, next_attempt,
+	last_attempt, response_status, last_error, created
+	FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		var lastAttempt sql.NullTime
+		var responseStatus sql.NullInt64
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttempt,
+			&lastAttempt, &responseStatus, &d.LastError, &d.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		d.LastAttempt = lastAttempt.Time
+		d.ResponseStatus = int(responseStatus.Int64)
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Deletes delivered and failed deliveries older than DeliveryRetention,
+// returning how many there were. Pending ones are kept however old they are.
+func (m *WebhookModel) Prune(ctx context.Context) (int, error) {
+	ctx, cancel := m.DB.WithTimeout(ctx)
+	defer cancel()
+
+	stmt := "DELETE FROM webhook_deliveries WHERE status IN (?, ?) AND created <= ?"
+
+	result, err := m.DB.ExecContext(ctx, stmt, DeliveryDelivered, DeliveryFailed, time.Now().Add(-DeliveryRetention).UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func (m *WebhookModel) attempt(ctx context.Context, id int, status string, responseStatus int, lastError string, next time.Time) error {
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, last_attempt = ?,
+	response_status = ?, last_error = ?, next_attempt = ? WHERE id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, status, time.Now().UTC(), nullInt(responseStatus), lastError, next.UTC(), id)
+	return err
+}
+
+func (m *WebhookModel) query(ctx context.Context, stmt string, args ...any) ([]*Webhook, error) {
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func scanWebhook(row scanner) (*Webhook, error) {
+	w := &Webhook{}
+
+	var events string
+
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.AllSnippets, &w.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Events = strings.Fields(events)
+
+	return w, nil
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/database"
+)
+
+func TestWebhookSubscribed(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		users := UserModel{DB: db}
+		m := WebhookModel{DB: db}
+
+		err := users.Insert(t.Context(), "Bob Jones", "bob", "bob@example.com", "pa$$word")
+		assert.NilError(t, err)
+
+		// Alice is user 1 in testdata/setup.sql, Bob is user 2
+		own, err := m.Insert(t.Context(), 1, "https://example.com/alice", []string{EventSnippetCreated}, false)
+		assert.NilError(t, err)
+		assert.Equal(t, strings.HasPrefix(own.Secret, webhookSecretPrefix), true)
+
+		site, err := m.Insert(t.Context(), 2, "https://example.com/bob", []string{EventSnippetCreated, EventSnippetDeleted}, true)
+		assert.NilError(t, err)
+
+		tests := []struct {
+			name    string
+			event   string
+			ownerID int
+			admin   bool
+			want    []int
+		}{
+			{
+				name:    "Owners webhook",
+				event:   EventSnippetCreated,
+				ownerID: 1,
+				want:    []int{own.ID},
+			},
+			{
+				name:    "Event not subscribed to",
+				event:   EventSnippetUpdated,
+				ownerID: 1,
+			},
+			{
+				name:    "Site wide webhook of an admin",
+				event:   EventSnippetCreated,
+				ownerID: 1,
+				admin:   true,
+				want:    []int{own.ID, site.ID},
+			},
+			{
+				name:    "Anonymous snippet",
+				event:   EventSnippetDeleted,
+				ownerID: 0,
+				admin:   true,
+				want:    []int{site.ID},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				role := RoleUser
+				if tt.admin {
+					role = RoleAdmin
+				}
+				err := users.SetRole(t.Context(), 2, role)
+				assert.NilError(t, err)
+
+				webhooks, err := m.Subscribed(t.Context(), tt.event, tt.ownerID)
+				assert.NilError(t, err)
+
+				got := []int{}
+				for _, w := range webhooks {
+					got = append(got, w.ID)
+				}
+
+				assert.Equal(t, len(got), len(tt.want))
+				for i := range tt.want {
+					assert.Equal(t, got[i], tt.want[i])
+				}
+			})
+		}
+	})
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := WebhookModel{DB: db}
+
+		webhook, err := m.Insert(t.Context(), 1, "https://example.com/hook", WebhookEvents, false)
+		assert.NilError(t, err)
+
+		err = m.Enqueue(t.Context(), webhook.ID, EventSnippetCreated, []byte(`{"event": "snippet.created"}`))
+		assert.NilError(t, err)
+
+		due, err := m.Claim(t.Context(), 10, time.Minute)
+		assert.NilError(t, err)
+		assert.Equal(t, len(due), 1)
+		assert.Equal(t, due[0].Status, DeliverySending)
+		assert.Equal(t, due[0].URL, webhook.URL)
+		assert.Equal(t, due[0].Secret, webhook.Secret)
+		assert.Equal(t, string(due[0].Payload), `{"event": "snippet.created"}`)
+
+		/* A retry is not due again until next */
+		err = m.Retry(t.Context(), due[0].ID, 500, "unexpected response", time.Now().Add(time.Minute))
+		assert.NilError(t, err)
+
+		due, err = m.Claim(t.Context(), 10, time.Minute)
+		assert.NilError(t, err)
+		assert.Equal(t, len(due), 0)
+
+		deliveries, err := m.Deliveries(t.Context(), webhook.ID, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(deliveries), 1)
+		assert.Equal(t, deliveries[0].Status, DeliveryPending)
+		assert.Equal(t, deliveries[0].Attempts, 1)
+		assert.Equal(t, deliveries[0].ResponseStatus, 500)
+		assert.Equal(t, deliveries[0].LastError, "unexpected response")
+
+		err = m.Delivered(t.Context(), deliveries[0].ID, 200)
+		assert.NilError(t, err)
+
+		deliveries, err = m.Deliveries(t.Context(), webhook.ID, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, deliveries[0].Status, DeliveryDelivered)
+		assert.Equal(t, deliveries[0].Attempts, 2)
+		assert.Equal(t, deliveries[0].LastError, "")
+
+		/* Deleting the webhook takes its delivery log with it */
+		err = m.Delete(t.Context(), webhook.ID, 2)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+		err = m.Delete(t.Context(), webhook.ID, 1)
+		assert.NilError(t, err)
+
+		deliveries, err = m.Deliveries(t.Context(), webhook.ID, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(deliveries), 0)
+	})
+}
+
+func TestWebhookClaim(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := WebhookModel{DB: db}
+
+		webhook, err := m.Insert(t.Context(), 1, "https://example.com/hook", WebhookEvents, false)
+		assert.NilError(t, err)
+
+		for range 20 {
+			err = m.Enqueue(t.Context(), webhook.ID, EventSnippetCreated, []byte(`{}`))
+			assert.NilError(t, err)
+		}
+
+		/* Workers running side by side, each delivery goes to exactly one of them */
+		var mu sync.Mutex
+		claimed := map[int]int{}
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					due, err := m.Claim(t.Context(), 3, time.Minute)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if len(due) == 0 {
+						return
+					}
+
+					mu.Lock()
+					for _, d := range due {
+						claimed[d.ID]++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, len(claimed), 20)
+		for id, n := range claimed {
+			if n != 1 {
+				t.Errorf("delivery %d was claimed %d times", id, n)
+			}
+		}
+
+		/* Once the lease is up a delivery whose worker went away is taken again */
+		_, err = db.Exec("UPDATE webhook_deliveries SET next_attempt = ? WHERE id = ?", time.Now().Add(-time.Second).UTC(), 1)
+		assert.NilError(t, err)
+
+		due, err := m.Claim(t.Context(), 10, time.Minute)
+		assert.NilError(t, err)
+		assert.Equal(t, len(due), 1)
+		assert.Equal(t, due[0].ID, 1)
+	})
+}
+
+func TestWebhookPrune(t *testing.T) {
+	eachDialect(t, func(t *testing.T, dialect database.Dialect) {
+		db := newTestDB(t, dialect)
+		m := WebhookModel{DB: db}
+
+		webhook, err := m.Insert(t.Context(), 1, "https://example.com/hook", WebhookEvents, false)
+		assert.NilError(t, err)
+
+		/* Delivered, failed and pending, in that order */
+		for range 3 {
+			err = m.Enqueue(t.Context(), webhook.ID, EventSnippetCreated, []byte(`{}`))
+			assert.NilError(t, err)
+		}
+		assert.NilError(t, m.Delivered(t.Context(), 1, 200))
+		assert.NilError(t, m.Fail(t.Context(), 2, 500, "unexpected response"))
+
+		_, err = db.Exec("UPDATE webhook_deliveries SET created = ?", time.Now().Add(-DeliveryRetention-time.Hour).UTC())
+		assert.NilError(t, err)
+
+		n, err := m.Prune(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, n, 2)
+
+		deliveries, err := m.Deliveries(t.Context(), webhook.ID, 10)
+		assert.NilError(t, err)
+		assert.Equal(t, len(deliveries), 1)
+		assert.Equal(t, deliveries[0].Status, DeliveryPending)
+	})
+}
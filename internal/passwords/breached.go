@@ -0,0 +1,113 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+type BreachedList interface {
+	Contains(password string) (bool, error)
+}
+
+// A local copy of a breached password list, so no network access is needed.
+// Every line holds the uppercase hex SHA-1 of a password, or a prefix of one,
+// optionally followed by ":<count>" as in the Have I Been Pwned downloads.
+// The lines must be sorted, the file is binary searched on every lookup
+// rather than loaded into memory as the full lists are several GB.
+type BreachedFile struct {
+	Path string
+}
+
+func (b *BreachedFile) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	/* Find the first line starting at or after an offset in [lo, hi) whose
+	   key is >= hash */
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		key, _, err := lineAfter(f, mid)
+		if err != nil {
+			return false, err
+		}
+
+		if key == "" || compareKey(key, hash) >= 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	key, _, err := lineAfter(f, lo)
+	if err != nil {
+		return false, err
+	}
+
+	return key != "" && compareKey(key, hash) == 0, nil
+}
+
+// Returns the key of the first complete line which starts at or after
+// offset, "" at the end of the file
+func lineAfter(f *os.File, offset int64) (string, int64, error) {
+	if offset > 0 {
+		/* Step back one byte so a line starting exactly at offset is found */
+		offset--
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+
+	if offset > 0 {
+		skipped, err := r.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				return "", 0, nil
+			}
+			return "", 0, err
+		}
+		offset += int64(len(skipped))
+	}
+
+	line, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+
+	line = bytes.TrimSpace(line)
+	key, _, _ := bytes.Cut(line, []byte(":"))
+
+	return strings.ToUpper(string(key)), offset, nil
+}
+
+/* Compares a possibly shortened key against the full hash */
+func compareKey(key, hash string) int {
+	if len(key) < len(hash) {
+		hash = hash[:len(key)]
+	}
+
+	return strings.Compare(key, hash)
+}
+
+/* An in memory list, mostly useful for tests */
+type BreachedSet map[string]bool
+
+func (b BreachedSet) Contains(password string) (bool, error) {
+	return b[password], nil
+}
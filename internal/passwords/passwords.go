@@ -0,0 +1,133 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownHash = errors.New("passwords: unknown hash format")
+	ErrInvalidHash = errors.New("passwords: invalid hash")
+)
+
+type Params struct {
+	/* Memory in KiB */
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// INFO: The RFC 9106 second recommended option, for when 2 GiB of memory per
+// hash is not an option
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hashes new passwords with argon2id in the PHC string format, eg.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, and verifies both those and
+// the bcrypt hashes which were stored before argon2id was introduced.
+type Hasher struct {
+	Params Params
+}
+
+func New(params Params) *Hasher {
+	return &Hasher{Params: params}
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Reports whether password matches the hash. needsRehash is true when the
+// hash is a legacy bcrypt hash or was made with different parameters than
+// the hasher is configured with, so the caller should store a new hash.
+func (h *Hasher) Verify(password, hash string) (match bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		return true, true, nil
+
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decode(hash)
+		if err != nil {
+			return false, false, err
+		}
+
+		otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+		if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+			return false, false, nil
+		}
+		return true, params != h.Params, nil
+
+	default:
+		return false, false, ErrUnknownHash
+	}
+}
+
+func decode(hash string) (Params, []byte, []byte, error) {
+	var params Params
+	var version int
+
+	/* "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key */
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	b64 := base64.RawStdEncoding
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
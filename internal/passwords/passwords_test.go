@@ -0,0 +1,73 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/* Cheap parameters so the tests stay fast */
+var testParams = Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHashAndVerify(t *testing.T) {
+	h := New(testParams)
+
+	// Longer than the 72 bytes bcrypt would silently truncate to
+	password := strings.Repeat("a", 80)
+
+	hash, err := h.Hash(password)
+	assert.NilError(t, err)
+	assert.StringContains(t, hash, "$argon2id$v=19$m=1024,t=1,p=1$")
+
+	match, needsRehash, err := h.Verify(password, hash)
+	assert.NilError(t, err)
+	assert.Equal(t, match, true)
+	assert.Equal(t, needsRehash, false)
+
+	match, _, err = h.Verify(strings.Repeat("a", 79)+"b", hash)
+	assert.NilError(t, err)
+	assert.Equal(t, match, false)
+
+	// Same hash checked by a hasher with stronger parameters
+	stronger := testParams
+	stronger.Iterations = 2
+
+	match, needsRehash, err = New(stronger).Verify(password, hash)
+	assert.NilError(t, err)
+	assert.Equal(t, match, true)
+	assert.Equal(t, needsRehash, true)
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	h := New(testParams)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa$$word"), bcrypt.MinCost)
+	assert.NilError(t, err)
+
+	match, needsRehash, err := h.Verify("pa$$word", string(hash))
+	assert.NilError(t, err)
+	assert.Equal(t, match, true)
+	assert.Equal(t, needsRehash, true)
+
+	match, _, err = h.Verify("wrong", string(hash))
+	assert.NilError(t, err)
+	assert.Equal(t, match, false)
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	h := New(testParams)
+
+	_, _, err := h.Verify("pa$$word", "plaintext")
+	assert.Equal(t, err, ErrUnknownHash)
+
+	_, _, err = h.Verify("pa$$word", "$argon2id$v=19$m=1024$salt")
+	assert.Equal(t, err, ErrInvalidHash)
+}
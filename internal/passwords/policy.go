@@ -0,0 +1,167 @@
+package passwords
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+// A Rule returns a message describing why password is not acceptable, or ""
+// if it is. personal holds things like the users name and email address which
+// should not be part of their password. err is only for failures such as not
+// being able to read the breached password list.
+type Rule func(password string, personal []string) (message string, err error)
+
+/* Rules are checked in order, only the first failing one is reported */
+type Policy struct {
+	Rules []Rule
+}
+
+func NewPolicy(rules ...Rule) *Policy {
+	return &Policy{Rules: rules}
+}
+
+// Adds a field error under key for the first rule password does not satisfy
+func (p *Policy) Validate(v *validator.Validator, key, password string, personal ...string) error {
+	for _, rule := range p.Rules {
+		message, err := rule(password, personal)
+		if err != nil {
+			return err
+		}
+
+		if message != "" {
+			v.AddFieldError(key, message)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func MinLength(n int) Rule {
+	return func(password string, personal []string) (string, error) {
+		if !validator.MinChars(password, n) {
+			return fmt.Sprintf("This field must be at least %d characters long", n), nil
+		}
+		return "", nil
+	}
+}
+
+// Rejects passwords with an estimated entropy of less than bits. Any part of
+// the password which is the users name or email address adds nothing.
+func MinEntropy(bits float64) Rule {
+	return func(password string, personal []string) (string, error) {
+		stripped := stripPersonal(password, personal)
+
+		if Entropy(stripped) >= bits {
+			return "", nil
+		}
+
+		if stripped != password {
+			return "This password is too easy to guess, avoid using your name or email address", nil
+		}
+		return "This password is too easy to guess, use a longer one or mix in other kinds of characters", nil
+	}
+}
+
+func NotBreached(list BreachedList) Rule {
+	return func(password string, personal []string) (string, error) {
+		breached, err := list.Contains(password)
+		if err != nil {
+			return "", err
+		}
+
+		if breached {
+			return "This password has appeared in a data breach, please choose another one", nil
+		}
+		return "", nil
+	}
+}
+
+// INFO: A rough estimate in the spirit of NIST SP 800-63 appendix A, the size
+// of the character pool the password draws from to the power of its length.
+// Runs like "aaaa" or "1234" only count their first character.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	var length int
+	var prev rune
+
+	for i, r := range password {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsLower(r):
+			lower = true
+		case r < utf8.RuneSelf && unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+
+		if i == 0 || (r != prev && r != prev+1 && r != prev-1) {
+			length++
+		}
+		prev = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(pool))
+}
+
+/* Removes the personal values, and the local part of email addresses, from password */
+func stripPersonal(password string, personal []string) string {
+	lowered := strings.ToLower(password)
+	/* INFO: Lowercasing can change the byte length of some runes, in which
+	   case the indexes would not line up so the search is case sensitive */
+	if len(lowered) != len(password) {
+		lowered = password
+	}
+
+	var parts []string
+	for _, p := range personal {
+		p = strings.ToLower(p)
+		if local, _, ok := strings.Cut(p, "@"); ok {
+			parts = append(parts, p, local)
+		} else {
+			parts = append(parts, p)
+		}
+		parts = append(parts, strings.Fields(p)...)
+	}
+
+	for _, part := range parts {
+		/* Too short to be meaningful, "al" would match far too much */
+		if utf8.RuneCountInString(part) < 3 {
+			continue
+		}
+
+		for {
+			i := strings.Index(lowered, part)
+			if i < 0 {
+				break
+			}
+			lowered = lowered[:i] + lowered[i+len(part):]
+			password = password[:i] + password[i+len(part):]
+		}
+	}
+
+	return password
+}
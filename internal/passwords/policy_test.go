@@ -0,0 +1,83 @@
+package passwords
+
+import (
+	"testing"
+
+	"github.com/mohafarman/snippetbox/internal/assert"
+	"github.com/mohafarman/snippetbox/internal/validator"
+)
+
+func TestBreachedFile(t *testing.T) {
+	list := &BreachedFile{Path: "testdata/breached.txt"}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"First line", "password", true},
+		{"Phrase", "correct horse battery staple", true},
+		{"Symbols", "Passw0rd!", true},
+		{"Not breached", "vivid-otter-ladder-42", false},
+		{"Empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breached, err := list.Contains(tt.password)
+
+			assert.NilError(t, err)
+			assert.Equal(t, breached, tt.want)
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := NewPolicy(
+		MinLength(8),
+		MinEntropy(40),
+		NotBreached(&BreachedFile{Path: "testdata/breached.txt"}),
+	)
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{
+			name:     "Strong",
+			password: "vivid-otter-ladder-42",
+		},
+		{
+			name:     "Too short",
+			password: "Ab1!",
+			want:     "This field must be at least 8 characters long",
+		},
+		{
+			name:     "Repetitive",
+			password: "aaaaaaaaaaaa",
+			want:     "This password is too easy to guess, use a longer one or mix in other kinds of characters",
+		},
+		{
+			name:     "Contains name",
+			password: "Alice-Jones-1",
+			want:     "This password is too easy to guess, avoid using your name or email address",
+		},
+		{
+			name:     "Breached",
+			password: "correct horse battery staple",
+			want:     "This password has appeared in a data breach, please choose another one",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator.Validator
+
+			err := policy.Validate(&v, "password", tt.password, "Alice Jones", "alice@example.com")
+
+			assert.NilError(t, err)
+			assert.Equal(t, v.FieldErrors["password"], tt.want)
+		})
+	}
+}
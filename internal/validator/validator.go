@@ -9,6 +9,12 @@ import (
 /* This pattern is parsed once at startup and stored to be used */
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+/* Lowercase letters, digits, "-" and "_", starting and ending with a letter or digit */
+var UsernameRX = regexp.MustCompile("^[a-z0-9](?:[a-z0-9_-]*[a-z0-9])?$")
+
+/* Language names such as "go", "c++", "c#" or "objective-c", or nothing at all */
+var LanguageRX = regexp.MustCompile("^[a-z0-9+#.-]*$")
+
 type Validator struct {
 	NonFieldErrors []string
 	FieldErrors    map[string]string